@@ -0,0 +1,58 @@
+// Characterize the workload in a log: fit a model of it that synthesize
+// can use to make bigger or longer load-test scripts of the same shape,
+// with keys of their own.
+// input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 200 GET"
+package main
+
+import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/vharitonsky/iniflags"
+)
+
+// main interprets the options and args.
+func main() {
+	var debug bool
+	var format, paths string
+	var step time.Duration
+
+	flag.StringVar(&format, "format", "perf", "input file format: perf, har, jtl, alb, cloudfront or s3log")
+	flag.DurationVar(&step, "step", time.Minute, "length of each step of the arrival rate")
+	flag.StringVar(&paths, "paths", "", "paths of the model's objects, with a %d, default /synthetic/%d and the commonest extension")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() < 1 {
+		fmt.Fprint(os.Stderr, "Usage: characterize [-format f][-step d][-paths p] load-file.csv > model.json\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	if filename == "" {
+		log.Fatalf("No load-test csv file provided, halting.\n")
+	}
+	if step <= 0 {
+		log.Fatalf("-step must be above zero, halting.\n")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s, halting.", filename, err)
+	}
+	defer f.Close() // nolint
+
+	loadtesting.Characterize(f, filename,
+		loadtesting.Config{
+			Debug:    debug,
+			Format:   format,
+			FitStep:  step,
+			FitPaths: paths,
+		})
+}
@@ -0,0 +1,54 @@
+// Convert load-test scripts and results between "perf" format
+// and other formats, such as browser HAR captures and JMeter results.
+package main
+
+import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vharitonsky/iniflags"
+)
+
+// main interprets the options and args.
+func main() {
+	var from, to string
+	var debug, perLabel bool
+	var err error
+
+	flag.StringVar(&from, "from", "perf", "input format: perf, har, jtl, alb, cloudfront or s3log")
+	flag.StringVar(&to, "to", "perf", "output format: perf, har or seconds")
+	flag.BoolVar(&perLabel, "per-label", false, "with --to seconds, aggregate each path or label separately")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() < 1 {
+		fmt.Fprint(os.Stderr, "Usage: convert [--from format][--to format] input-file [baseURL]\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	if filename == "" {
+		log.Fatalf("No input file provided, halting.\n")
+	}
+	baseURL := flag.Arg(1)
+	if baseURL == "" && to == "har" {
+		log.Fatalf("HAR output requires a base url, halting.\n")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s, halting.", filename, err)
+	}
+	defer f.Close() // nolint
+
+	loadtesting.Convert(f, filename, from, to, baseURL,
+		loadtesting.Config{
+			Debug:    debug,
+			PerLabel: perLabel,
+		})
+}
@@ -0,0 +1,75 @@
+// Describe a load-test script in "perf" format: report malformed
+// records and summarize the workload, before booking a test.
+// input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 200 GET"
+package main
+
+import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vharitonsky/iniflags"
+)
+
+// main interprets the options and args.
+func main() {
+	var ro bool
+	var rw, wo int64
+	var debug bool
+	var format string
+	var err error
+
+	flag.BoolVar(&ro, "ro", false, "check as a read-only test")
+	flag.Int64Var(&rw, "rw", 0, "check as a read-write test, w buffer size")
+	flag.Int64Var(&wo, "wo", 0, "check as a write-only test, w buffer size")
+	flag.StringVar(&format, "format", "perf", "input file format: perf, har, jtl, alb, cloudfront or s3log")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() < 1 {
+		fmt.Fprint(os.Stderr, "Usage: describe [--ro|--rw N|--wo N][--format f] load-file.csv\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	if filename == "" {
+		log.Fatalf("No load-test csv file provided, halting.\n")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s, halting.", filename, err)
+	}
+	defer f.Close() // nolint
+
+	r, w := setMode(ro, rw, wo)
+	malformed := loadtesting.Describe(f, filename,
+		loadtesting.Config{
+			Debug:  debug,
+			R:      r,
+			W:      w,
+			Format: format,
+		})
+	if malformed > 0 {
+		log.Printf("%d malformed records found in %s\n", malformed, filename)
+		os.Exit(1)
+	}
+}
+
+// setMode sets the r and w booleans the same way runLoadTest does
+func setMode(ro bool, rw, wo int64) (bool, bool) {
+	switch {
+	case ro:
+		return true, false
+	case rw != 0:
+		return true, true
+	case wo != 0:
+		return false, true
+	default: // treat as ro if not set
+		return true, false
+	}
+}
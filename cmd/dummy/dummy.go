@@ -1,27 +1,64 @@
 package main
 
-// dummy -- This is a web service that just returns the parameter it was called with
+// dummy -- a simulated system under test, for developing and checking
+// load tests without real hardware. By default it just returns the path
+// it was called with.
 // 	Based on "hello world", https://www.atlantic.net/dedicated-server-hosting/deploying-a-go-web-application-using-nginx-on-ubuntu-22-04/
 
 import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
-)
+	"os"
+	"time"
 
-const addr = ":9990"
+	"github.com/vharitonsky/iniflags"
+)
 
 func main() {
+	var port, concurrency, queue, servers int
+	var latency, sizes, errors, model, buckets string
+	var service time.Duration
+	var debug, s3 bool
+
+	flag.IntVar(&port, "port", 9990, "port to listen on")
+	flag.StringVar(&latency, "latency", "", "service times, as pattern=distribution;...")
+	flag.StringVar(&sizes, "sizes", "", "perf file to take response sizes from")
+	flag.StringVar(&errors, "errors", "", "return codes to inject, as rc:rate;...")
+	flag.IntVar(&concurrency, "concurrency", 0, "requests served at once, default any number")
+	flag.IntVar(&queue, "queue", 0, "requests allowed to wait for a slot, default any number")
+	flag.StringVar(&model, "model", "", "be an M/M/c or M/D/c queue")
+	flag.IntVar(&servers, "servers", 1, "the model's number of servers, c")
+	flag.DurationVar(&service, "service", 10*time.Millisecond, "the model's mean service time")
+	flag.BoolVar(&s3, "s3", false, "be a small S3, with path-style urls")
+	flag.StringVar(&buckets, "buckets", "test", "comma-separated buckets for --s3 to start with")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() > 0 {
+		fmt.Fprint(os.Stderr, "Usage: dummy [--port n][--latency spec][--sizes load.csv]"+
+			"[--errors spec][--concurrency n][--queue n]\n"+
+			"\t[--model M/M/c --servers c --service t][--s3 [--buckets b1,b2]][-d]\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		//grab the next part of the url as a parameter
-		param := r.URL.Path[len("/"):]
-		if param == "" {
-			param = "nothing at all"
-		}
-		fmt.Fprintf(w, "Got %q\n", param)
-	})
-
-	http.ListenAndServe(addr, nil)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	loadtesting.Simulate(fmt.Sprintf(":%d", port),
+		loadtesting.Config{
+			Debug:          debug,
+			SimLatency:     latency,
+			SimSizes:       sizes,
+			SimErrors:      errors,
+			SimConcurrency: concurrency,
+			SimQueue:       queue,
+			SimModel:       model,
+			SimServers:     servers,
+			SimService:     service,
+			SimS3:          s3,
+			SimBuckets:     buckets,
+		})
 }
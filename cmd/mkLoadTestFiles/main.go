@@ -12,6 +12,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"strings"
 
 	"github.com/vharitonsky/iniflags"
 )
@@ -21,19 +22,40 @@ import (
 // main interprets the options and args.
 func main() {
 	var startFrom, runFor int
-	var verbose, zero bool
+	var verbose, debug, zero bool
+	var s3, rest, fs bool
+	var workers, rate int
+	var manifest, content string
+	var resume bool
+	var s3Bucket, s3Key, s3Secret string
 	var err error
 
 	flag.IntVar(&runFor, "for", 0, "number of records to use, eg 1000 ")
 	flag.IntVar(&startFrom, "from", 0, "number of records to skip, eg 100")
 	flag.BoolVar(&zero, "zero", false, "create zero-size files")
+	flag.StringVar(&content, "content", "random", "content to write: random or compressible")
+	flag.IntVar(&workers, "workers", 1, "number of files to create at once")
+	flag.IntVar(&rate, "rate", 0, "files to create per second, 0 for no limit")
+	flag.StringVar(&manifest, "manifest", "", "file to list the paths, sizes and checksums created in")
+	flag.BoolVar(&resume, "resume", false, "skip the files the manifest lists, and add to it")
 	flag.BoolVar(&verbose, "v", false, "verbose")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	flag.BoolVar(&s3, "s3", false, "create objects with the s3 protocol")
+	flag.BoolVar(&rest, "rest", false, "create objects with rest PUTs, the default with an http(s) url")
+	flag.BoolVar(&fs, "fs", false, "create files under the directory given as the url, the default otherwise")
+	flag.StringVar(&s3Bucket, "s3-bucket", "BUCKET NOT SET",
+		"set bucket when using s3 protocol")
+	flag.StringVar(&s3Key, "s3-key", "KEY NOT SET",
+		"set key when using s3 protocol")
+	flag.StringVar(&s3Secret, "s3-secret", "SECRET NOT SET",
+		"set secret when using s3 protocol")
 
 	iniflags.Parse()
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
 
 	if flag.NArg() < 1 {
-		fmt.Fprint(os.Stderr, "Usage: mkLoadTestFiles [-v][--from N --for N] load-file.csv [url]\n") //nolint
+		fmt.Fprint(os.Stderr, "Usage: mkLoadTestFiles [-v][--from N --for N][-s3|-rest|-fs] load-file.csv [url]\n") //nolint
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
@@ -45,7 +67,11 @@ func main() {
 		runFor = math.MaxInt64
 	}
 	baseURL := flag.Arg(1)
+	proto := setProtocol(s3, rest, fs, baseURL)
 	if baseURL == "" {
+		if proto != loadtesting.FilesystemProtocol {
+			log.Fatalf("No url provided to create objects at, halting.\n")
+		}
 		log.Printf("No url provided, writing to current directory")
 	}
 
@@ -57,11 +83,38 @@ func main() {
 
 	loadtesting.MkLoadTestFiles(f, filename, baseURL, startFrom, runFor,
 		loadtesting.Config{
-			Verbose:  verbose,
-			Protocol: loadtesting.FilesystemProtocol,
-			Strip:    "",
-			Zero:     zero,
+			Verbose:    verbose,
+			Debug:      debug,
+			Crash:      true, // stop at the first object we can't create
+			Protocol:   proto,
+			Strip:      "",
+			Zero:       zero,
+			S3Bucket:   s3Bucket,
+			S3Key:      s3Key,
+			S3Secret:   s3Secret,
+			Content:    content,
+			MkWorkers:  workers,
+			MkRate:     rate,
+			MkManifest: manifest,
+			MkResume:   resume,
 			// TerminationTimeout is 0
 		})
 
 }
+
+// setProtocol picks the protocol from the options, or else from the
+// url: http:// and https:// are PUT to, and anything else is a directory
+func setProtocol(s3, rest, fs bool, baseURL string) int {
+	switch {
+	case s3:
+		return loadtesting.S3Protocol
+	case rest:
+		return loadtesting.RESTProtocol
+	case fs:
+		return loadtesting.FilesystemProtocol
+	case strings.HasPrefix(baseURL, "http://"), strings.HasPrefix(baseURL, "https://"):
+		return loadtesting.RESTProtocol
+	default:
+		return loadtesting.FilesystemProtocol
+	}
+}
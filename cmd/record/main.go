@@ -0,0 +1,52 @@
+// Record live traffic in "perf" format, by proxying it to a real server.
+// output looks like "2017-03-01 16:00:00.000 0.012 0.001 0 1234 /path 200 GET"
+package main
+
+import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vharitonsky/iniflags"
+)
+
+// main interprets the options and args.
+func main() {
+	var addr, keepHeaders string
+	var bodies, debug bool
+
+	flag.StringVar(&addr, "listen", ":8080", "address to listen on")
+	flag.BoolVar(&bodies, "bodies", false, "record request bodies, for POST replay")
+	flag.StringVar(&keepHeaders, "keep-headers", "", "comma-separated request headers to record")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() < 1 {
+		fmt.Fprint(os.Stderr, "Usage: record [--listen addr][--bodies][--keep-headers h1,h2] upstreamURL >load.csv\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	upstream := flag.Arg(0)
+	if upstream == "" {
+		log.Fatalf("No upstream url provided, halting.\n")
+	}
+	var headers []string
+	for _, h := range strings.Split(keepHeaders, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			headers = append(headers, h)
+		}
+	}
+
+	loadtesting.Record(addr, upstream,
+		loadtesting.Config{
+			Debug:         debug,
+			RecordBodies:  bodies,
+			RecordHeaders: headers,
+		})
+}
@@ -11,6 +11,7 @@ import (
 	"math"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/vharitonsky/iniflags"
 )
@@ -30,14 +31,28 @@ func usage() {
 func main() {
 	var tpsTarget, progressRate, stepDuration, startTps int
 	var startFrom, runFor int
-	var s3, ceph, rest, timeBudget bool
+	var s3, ceph, rest, timeBudget, grpc, webSocket, fs bool
+	var fsDirect, fsSync bool
+	var fsBuffer int
+	var grpcDescriptors string
 	var ro bool
 	var rw, wo int64
 	var bufSize int64
 	var s3Bucket, s3Key, s3Secret string
 	var verbose, debug, crash, akamaiDebug bool
-	var serial, cache, tail, rewind bool
-	var strip, hostHeader, headers string
+	var serial, cache, tail, rewind, template bool
+	var strip, hostHeader, headers, format string
+	var vars, dataFile string
+	var authKind, authUser, authSecret, authTokenURL, authScopes string
+	var tlsCA, tlsCert, tlsKey, tlsServerName, tlsMinVersion, tlsCiphers string
+	var tlsInsecure, trace bool
+	var keepAlive, clientPerWorker bool
+	var maxConnsPerHost, maxIdleConns, h2Streams int
+	var httpVersion string
+	var faults, content string
+	var verify bool
+	var faultReadRate, faultHeaderSize int
+	var idleTimeout, timeout, dialTimeout time.Duration
 	var headerMap = make(map[string]string)
 	var err error
 
@@ -51,19 +66,62 @@ func main() {
 	flag.BoolVar(&s3, "s3", false, "use s3 protocol")
 	flag.BoolVar(&rest, "rest", false, "use rest protocol")
 	flag.BoolVar(&timeBudget, "timeBudget", false, "test the time budget")
+	flag.BoolVar(&grpc, "grpc", false, "use grpc protocol")
+	flag.BoolVar(&webSocket, "websocket", false, "use websocket protocol")
+	flag.BoolVar(&fs, "fs", false, "use the local filesystem, under the directory given as the url")
+	flag.BoolVar(&fsDirect, "fs-direct", false, "bypass the page cache with O_DIRECT")
+	flag.BoolVar(&fsSync, "fs-sync", false, "fsync after each write")
+	flag.IntVar(&fsBuffer, "fs-buffer", 0, "bytes per filesystem read, default 128KiB")
+	flag.StringVar(&grpcDescriptors, "grpc-descriptors", "", "descriptor set for grpc, instead of server reflection")
 
 	flag.BoolVar(&ro, "ro", false, "read-only test")
 	flag.Int64Var(&rw, "rw", 0, "read-write test, w buffer size")
 	flag.Int64Var(&wo, "wo", 0, "write-only test, w buffer size")
+	flag.StringVar(&content, "content", "random", "content to write: random or compressible")
+	flag.BoolVar(&verify, "verify", false, "check that GETs return what was written")
 
 	flag.BoolVar(&serial, "serialize", false, "serialize load (only for load testing)")
 	flag.StringVar(&strip, "strip", "", "text to strip from paths")
 	flag.StringVar(&hostHeader, "host-header", "", "add a Host: header")
-	flag.StringVar(&headers, "headers", "", "add one or more key:value headers")
+	flag.StringVar(&headers, "headers", "", "add one or more key:value headers, separated by ; if values have spaces")
+
+	flag.StringVar(&authKind, "auth", "", "authenticate with basic, bearer, oauth2 or hmac")
+	flag.StringVar(&authUser, "auth-user", "", "user, oauth2 client id or hmac key id")
+	flag.StringVar(&authSecret, "auth-secret", "", "password, token or secret, as text, env:NAME or file:path")
+	flag.StringVar(&authTokenURL, "auth-token-url", "", "oauth2 token endpoint")
+	flag.StringVar(&authScopes, "auth-scopes", "", "oauth2 scopes, space-separated")
+
+	flag.StringVar(&tlsCA, "tls-ca", "", "CA bundle to trust, in pem")
+	flag.StringVar(&tlsCert, "tls-cert", "", "client certificate, in pem")
+	flag.StringVar(&tlsKey, "tls-key", "", "client certificate key, in pem")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "server name to send and verify, if not the url's")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "", "minimum tls version: 1.0, 1.1, 1.2 or 1.3")
+	flag.StringVar(&tlsCiphers, "tls-ciphers", "", "comma-separated cipher suites to allow")
+	flag.BoolVar(&tlsInsecure, "tls-insecure", false, "don't verify the server's certificate")
+	flag.BoolVar(&trace, "trace", false, "add dns, connect, tls and ttfb times to each result")
+
+	flag.BoolVar(&keepAlive, "keep-alive", true, "reuse connections, or use -keep-alive=false for one per request")
+	flag.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "limit on connections per host, 0 for none")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", loadtesting.MaxIdleConnections, "idle connections kept per host")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 90*time.Second, "how long to keep idle connections")
+	flag.DurationVar(&timeout, "timeout", 0, "per-request timeout, eg 30s, 0 for none")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 30*time.Second, "connect timeout")
+	flag.BoolVar(&clientPerWorker, "client-per-worker", false, "make each worker a distinct client, with its own connections")
+	flag.StringVar(&httpVersion, "http", "1.1", "http version: 1.1, auto, 2 (over tls) or h2c (cleartext)")
+	flag.IntVar(&h2Streams, "h2-streams", 0, "concurrent streams per HTTP/2 connection, 0 for the server's limit")
+
+	flag.StringVar(&faults, "faults", "", "client faults to commit, kind:rate;... with kinds slow-read, abort, truncate, big-header and malformed")
+	flag.IntVar(&faultReadRate, "fault-read-rate", 1024, "bytes/second a slow-read client reads at")
+	flag.IntVar(&faultHeaderSize, "fault-header-size", 64*1024, "bytes of header a big-header client sends")
+
+	flag.BoolVar(&template, "template", false, "expand ${name} in paths, bodies and headers")
+	flag.StringVar(&vars, "vars", "", "one or more name=value template variables")
+	flag.StringVar(&dataFile, "data", "", "csv file of template values, with a header line")
 
 	flag.BoolVar(&cache, "cache", false, "allow caching")
 	flag.BoolVar(&tail, "tail", false, "tail -f the input file")
 	flag.BoolVar(&rewind, "rewind", false, "rewind the input file at EOF and continue")
+	flag.StringVar(&format, "format", "perf", "input file format: perf, har, jtl, alb, cloudfront or s3log")
 
 	flag.BoolVar(&debug, "d", false, "add debugging messages")
 	flag.BoolVar(&verbose, "v", false, "add verbose messages")
@@ -101,7 +159,7 @@ func main() {
 		bufSize = rw
 	}
 
-	proto := setProtocol(s3, ceph, timeBudget)
+	proto := setProtocol(s3, ceph, timeBudget, grpc, webSocket, fs)
 	filename := flag.Arg(0)
 	if filename == "" {
 		log.Fatalf("No load-test .csv file provided, halting.\n")
@@ -126,46 +184,91 @@ func main() {
 	loadtesting.RunLoadTest(f, filename, startFrom, runFor,
 		tpsTarget, progressRate, startTps, baseURL,
 		loadtesting.Config{
-			Verbose:      verbose,
-			Debug:        debug,
-			Crash:        crash,
-			AkamaiDebug:  akamaiDebug,
-			Serialize:    serial,
-			Cache:        cache,
-			Tail:         tail,
-			Rewind:       rewind,
-			Protocol:     proto,
-			S3Key:        s3Key,
-			S3Secret:     s3Secret,
-			S3Bucket:     s3Bucket,
-			Strip:        strip,
-			StepDuration: stepDuration,
-			HostHeader:   hostHeader,
-			HeaderMap:    headerMap,
-			R:            r,
-			W:            w,
-			BufSize:      bufSize,
+			Verbose:         verbose,
+			Debug:           debug,
+			Crash:           crash,
+			AkamaiDebug:     akamaiDebug,
+			Serialize:       serial,
+			Cache:           cache,
+			Tail:            tail,
+			Rewind:          rewind,
+			Protocol:        proto,
+			S3Key:           s3Key,
+			S3Secret:        s3Secret,
+			S3Bucket:        s3Bucket,
+			Strip:           strip,
+			StepDuration:    stepDuration,
+			HostHeader:      hostHeader,
+			HeaderMap:       headerMap,
+			R:               r,
+			W:               w,
+			BufSize:         bufSize,
+			Format:          format,
+			Template:        template,
+			Vars:            vars,
+			DataFile:        dataFile,
+			Auth:            authKind,
+			AuthUser:        authUser,
+			AuthSecret:      authSecret,
+			AuthTokenURL:    authTokenURL,
+			AuthScopes:      authScopes,
+			TLSCA:           tlsCA,
+			TLSCert:         tlsCert,
+			TLSKey:          tlsKey,
+			TLSServerName:   tlsServerName,
+			TLSMinVersion:   tlsMinVersion,
+			TLSCiphers:      tlsCiphers,
+			TLSInsecure:     tlsInsecure,
+			Trace:           trace,
+			NoKeepAlive:     !keepAlive,
+			MaxConnsPerHost: maxConnsPerHost,
+			MaxIdleConns:    maxIdleConns,
+			IdleTimeout:     idleTimeout,
+			Timeout:         timeout,
+			DialTimeout:     dialTimeout,
+			ClientPerWorker: clientPerWorker,
+			HTTPVersion:     httpVersion,
+			H2Streams:       h2Streams,
+			GRPCDescriptors: grpcDescriptors,
+			FSDirect:        fsDirect,
+			FSSync:          fsSync,
+			FSBufferSize:    fsBuffer,
+			Faults:          faults,
+			Content:         content,
+			Verify:          verify,
+			FaultReadRate:   faultReadRate,
+			FaultHeaderSize: faultHeaderSize,
 		})
 	// test ends:w
 
 }
 
-// setheaders creates a proper map of header:value pairs
+// setheaders creates a proper map of header:value pairs. They are
+// separated by semicolons if there are any, so values can contain
+// spaces, otherwise by spaces. Values may contain colons.
 func setHeaders(headers string, headerMap map[string]string) {
 	if headers != "" {
-		tokens := strings.Split(headers, " ")
+		separator := " "
+		if strings.Contains(headers, ";") {
+			separator = ";"
+		}
+		tokens := strings.Split(headers, separator)
 		for _, t := range tokens {
-			x := strings.Split(t, ":")
-			if len(x) != 2 || x[0] == "" || x[1] == "" {
+			if strings.TrimSpace(t) == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(t, ":")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || key == "" || value == "" {
 				log.Fatalf("headers must contain a key:value pair, found %q instead\n", t)
 			}
-			headerMap[x[0]] = x[1]
+			headerMap[key] = value
 		}
 	}
 }
 
-// setProtocol from s3 and ceph booleans
-func setProtocol(s3, ceph, timeBudget bool) int {
+// setProtocol from s3, ceph, timeBudget, grpc, webSocket and fs booleans
+func setProtocol(s3, ceph, timeBudget, grpc, webSocket, fs bool) int {
 	var proto int
 
 	switch {
@@ -175,6 +278,12 @@ func setProtocol(s3, ceph, timeBudget bool) int {
 		proto = loadtesting.CephProtocol // unimplemented
 	case timeBudget:
 		proto = loadtesting.TimeBudgetProtocol
+	case grpc:
+		proto = loadtesting.GRPCProtocol
+	case webSocket:
+		proto = loadtesting.WebSocketProtocol
+	case fs:
+		proto = loadtesting.FilesystemProtocol
 	default: //REST
 		proto = loadtesting.RESTProtocol
 	}
@@ -0,0 +1,57 @@
+// Synthesize a load-test script in "perf" format from a model of the
+// workload: its mix of operations, how popular objects are, how big
+// they are, how fast requests arrive and what codes to expect.
+// output looks like "2024-05-01 09:00:00.013 0 0 0 8101 /object-1.jpg 200 GET"
+package main
+
+import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vharitonsky/iniflags"
+)
+
+// main interprets the options and args.
+func main() {
+	var debug bool
+	var seed int64
+	var scale, longer float64
+
+	flag.Int64Var(&seed, "seed", 0, "random seed, 0 for the model's")
+	flag.Float64Var(&scale, "scale", 1, "multiply the model's rates by this")
+	flag.Float64Var(&longer, "longer", 1, "multiply the model's durations by this")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() < 1 {
+		fmt.Fprint(os.Stderr, "Usage: synthesize [-seed N][-scale X][-longer X] model.json > load-file.csv\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	if filename == "" {
+		log.Fatalf("No model file provided, halting.\n")
+	}
+	if scale <= 0 || longer <= 0 {
+		log.Fatalf("-scale and -longer must be above zero, halting.\n")
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s, halting.", filename, err)
+	}
+	defer f.Close() // nolint
+
+	loadtesting.Synthesize(f, filename,
+		loadtesting.Config{
+			Debug:       debug,
+			SynthSeed:   seed,
+			SynthScale:  scale,
+			SynthLonger: longer,
+		})
+}
@@ -0,0 +1,97 @@
+// Delete the files or objects a load test used, from the load script in
+// "perf" format it was made for, or from a list of paths such as
+// mkLoadTestFiles' manifest.
+// input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 200 GET"
+package main
+
+import (
+	"github.com/davecb/Play-it-Again-Sam/pkg/loadtesting"
+
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/vharitonsky/iniflags"
+)
+
+// main interprets the options and args.
+func main() {
+	var verbose, debug, list, dryRun bool
+	var s3, rest, fs bool
+	var workers, rate int
+	var s3Bucket, s3Key, s3Secret string
+
+	flag.BoolVar(&list, "list", false, "the file is a list of paths, one per line, or a manifest")
+	flag.BoolVar(&dryRun, "dry-run", false, "list what would be deleted, and delete nothing")
+	flag.IntVar(&workers, "workers", 1, "number of files to delete at once")
+	flag.IntVar(&rate, "rate", 0, "files to delete per second, 0 for no limit")
+	flag.BoolVar(&verbose, "v", false, "verbose")
+	flag.BoolVar(&debug, "d", false, "add debugging messages")
+
+	flag.BoolVar(&s3, "s3", false, "delete objects with the s3 protocol")
+	flag.BoolVar(&rest, "rest", false, "delete objects with rest DELETEs, the default with an http(s) url")
+	flag.BoolVar(&fs, "fs", false, "delete files under the directory given as the url, the default otherwise")
+	flag.StringVar(&s3Bucket, "s3-bucket", "BUCKET NOT SET",
+		"set bucket when using s3 protocol")
+	flag.StringVar(&s3Key, "s3-key", "KEY NOT SET",
+		"set key when using s3 protocol")
+	flag.StringVar(&s3Secret, "s3-secret", "SECRET NOT SET",
+		"set secret when using s3 protocol")
+
+	iniflags.Parse()
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime) // show file:line in logs
+
+	if flag.NArg() < 2 {
+		fmt.Fprint(os.Stderr, "Usage: teardown [-v][-dry-run][-list][-workers N -rate N][-s3|-rest|-fs] load-file.csv url\n") //nolint
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	filename := flag.Arg(0)
+	if filename == "" {
+		log.Fatalf("No load-test csv file or list provided, halting.\n")
+	}
+	baseURL := flag.Arg(1)
+	if baseURL == "" {
+		// too easy to delete the wrong thing in the current directory
+		log.Fatalf("No url provided, use . for the current directory, halting.\n")
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Error opening %s: %s, halting.", filename, err)
+	}
+	defer f.Close() // nolint
+
+	loadtesting.Teardown(f, filename, baseURL,
+		loadtesting.Config{
+			Verbose:         verbose,
+			Debug:           debug,
+			Protocol:        setProtocol(s3, rest, fs, baseURL),
+			S3Bucket:        s3Bucket,
+			S3Key:           s3Key,
+			S3Secret:        s3Secret,
+			TeardownList:    list,
+			TeardownWorkers: workers,
+			TeardownRate:    rate,
+			DryRun:          dryRun,
+		})
+}
+
+// setProtocol picks the protocol from the options, or else from the
+// url, as mkLoadTestFiles does
+func setProtocol(s3, rest, fs bool, baseURL string) int {
+	switch {
+	case s3:
+		return loadtesting.S3Protocol
+	case rest:
+		return loadtesting.RESTProtocol
+	case fs:
+		return loadtesting.FilesystemProtocol
+	case strings.HasPrefix(baseURL, "http://"), strings.HasPrefix(baseURL, "https://"):
+		return loadtesting.RESTProtocol
+	default:
+		return loadtesting.FilesystemProtocol
+	}
+}
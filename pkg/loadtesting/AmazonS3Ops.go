@@ -6,8 +6,10 @@ package loadtesting
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -27,7 +29,7 @@ var svc *s3.S3
 var awsLogLevel = aws.LogOff
 
 // Get does a get operation from an s3Protocol target and times it,
-func (p S3Proto) Get(path string, oldRc string) {
+func (p S3Proto) Get(path string, oldRc string, o options) {
 	if conf.Debug {
 		log.Printf("in AmazonS3Get(%s, %s)\n", p.prefix, path)
 
@@ -51,11 +53,12 @@ func (p S3Proto) Get(path string, oldRc string) {
 		}
 	}
 
-	file, err := os.CreateTemp("/tmp", "loadTesting")
+	file, err := os.CreateTemp(scratchDir, "get-")
 	if err != nil {
 		log.Fatalf("Unable to create a temp file,  %v", err)
 	}
 	defer os.Remove(file.Name()) // nolint
+	defer file.Close()           // nolint
 
 	downloader := s3manager.NewDownloaderWithClient(svc)
 	initial := time.Now() //              				***** Response time starts
@@ -66,68 +69,74 @@ func (p S3Proto) Get(path string, oldRc string) {
 		})
 	responseTime := time.Since(initial) // 				***** Response time ends
 	if err != nil {
-		rc := errorCodeToHTTPCode(err)
-		fmt.Printf("%s %f 0 0 %d %s %d GET\n",
-			initial.Format("2006-01-02 15:04:05.000"),
-			responseTime.Seconds(), numBytes, path, rc)
-		reportPerformance(initial, responseTime, 0, nil, path, rc, oldRc)
+		reportPerformance(initial, responseTime, 0, numBytes, path, errorCodeToHTTPCode(err), "GET", oldRc,
+			nil, "")
 		return
 	}
-	fmt.Printf("%s %f 0 0 %d %s 200 GET\n",
-		initial.Format("2006-01-02 15:04:05.000"),
-		responseTime.Seconds(), numBytes, path)
-	reportPerformance(initial, responseTime, 0, nil, path, 200, oldRc)
+	var verified string
+	if conf.Verify {
+		verified = verifyResult(path, verifyContent(path, numBytes, io.NewSectionReader(file, 0, numBytes)))
+	}
+	reportPerformance(initial, responseTime, 0, numBytes, path, 200, "GET", oldRc, nil, verified)
 }
 
 // Put puts a file and times it
-// error return is used only by mkLoadTestFiles  FIXME
-func (p S3Proto) Put(path, size, oldRC string) {
-	log.Fatalf("put is not implemented yet\n")
-	//if conf.Debug {
-	//	log.Printf("in AmazonS3Put(%s, %s, %d)\n", p.prefix, path, size)
-	//}
-	//
-	//file, err := os.Open(junkDataFile)
-	//if err != nil {
-	//	return fmt.Errorf("Unable to open junk-data file %s, %v", junkDataFile, err)
-	//}
-	//defer file.Close() // nolint
-	//lr := io.LimitReader(file, size)
-	//
-	//if svc == nil {
-	//	return fmt.Errorf("missing service %v", svc)
-	//}
-	//uploader := s3manager.NewUploaderWithClient(svc)
-	//initial := time.Now() //              				***** Response time starts
-	//_, err = uploader.Upload(&s3manager.UploadInput{
-	//	Bucket: aws.String(conf.S3Bucket),
-	//	Key:    aws.String(path),
-	//	Body:   lr,
-	//})
-	//responseTime := time.Since(initial) // 				***** Response time ends
-	//// FIXME swap this around
-	//if err == nil {
-	//	fmt.Printf("%s %f 0 0 %d %s 201 PUT\n",
-	//		initial.Format("2006-01-02 15:04:05.000"),
-	//		responseTime.Seconds(), size, path)
-	//	return nil
-	//}
-	//// This doesn't seem to do what one expects: FIXME?
-	//// reqerr, ok := err.(awserr.RequestFailure)
-	////if ok {
-	////	log.Printf("%s %f 0 0 %d %s %d GET\n",
-	////		initial.Format("2006-01-02 15:04:05.000"),
-	////		responseTime.Seconds(), size, path, reqerr.StatusCode)
-	//// return nil
-	////}
-	//fmt.Printf("%s %f 0 0 %d %s 4XX GET\n",
-	//	initial.Format("2006-01-02 15:04:05.000"),
-	//	responseTime.Seconds(), size, path)
-	//return fmt.Errorf("unable to upload %q to %q, %v", path, conf.S3Bucket, err)
+func (p S3Proto) Put(path, size, oldRC string, o options) {
+	if conf.Debug {
+		log.Printf("in AmazonS3Put(%s, %s, %s)\n", p.prefix, path, size)
+	}
+	bytes, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		log.Fatalf("put size %q was unreadable, %v, halting\n", size, err)
+	}
+	uploader := s3manager.NewUploaderWithClient(svc)
+	initial := time.Now() //              				***** Response time starts
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(conf.S3Bucket),
+		Key:    aws.String(path),
+		Body:   newContent(path, bytes),
+	})
+	responseTime := time.Since(initial) // 				***** Response time ends
+	rc := 201
+	if err != nil {
+		rc = errorCodeToHTTPCode(err)
+		if conf.Verbose || conf.Crash {
+			log.Printf("unable to upload %q to %q, %v\n", path, conf.S3Bucket, err)
+		}
+		if conf.Crash {
+			log.Fatalf("halting.\n")
+		}
+	}
+	reportPerformance(initial, responseTime, 0, bytes, path, rc, "PUT", oldRC, nil, "")
+	o.setRC(rc)
+}
+
+// Delete deletes an object and times it
+func (p S3Proto) Delete(path, oldRC string, o options) {
+	if conf.Debug {
+		log.Printf("in AmazonS3Delete(%s, %s)\n", p.prefix, path)
+	}
+	initial := time.Now() //              				***** Response time starts
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(conf.S3Bucket),
+		Key:    aws.String(path),
+	})
+	responseTime := time.Since(initial) // 				***** Response time ends
+	rc := 204
+	if err != nil {
+		rc = errorCodeToHTTPCode(err)
+		if conf.Verbose || conf.Crash {
+			log.Printf("unable to delete %q from %q, %v\n", path, conf.S3Bucket, err)
+		}
+		if conf.Crash {
+			log.Fatalf("halting.\n")
+		}
+	}
+	reportPerformance(initial, responseTime, 0, 0, path, rc, "DELETE", oldRC, nil, "")
 }
 
 // Post for s3: not implemented yes
-func (p S3Proto) Post(path, size, oldRC, body string) {
+func (p S3Proto) Post(path, size, oldRC, body string, o options) {
 	log.Fatalf("POST is unimplemented\n")
 }
 
@@ -153,6 +162,7 @@ func mustCreateService(myEndpoint string, awsLogLevel aws.LogLevelType) *s3.S3 {
 		WithEndpoint(myEndpoint).
 		WithDisableSSL(true).
 		WithS3ForcePathStyle(true).
+		WithHTTPClient(httpClient).
 		WithCredentials(creds)
 	sess, err := session.NewSession() // There is a session.Must() for convenience
 	if err != nil {
@@ -19,7 +19,7 @@ func (p timeBudgetProto) Init() {
 }
 
 // Get does a GET that should take one tenth of a second
-func (p timeBudgetProto) Get(path string, oldRc string) {
+func (p timeBudgetProto) Get(path string, oldRc string, o options) {
 	if conf.Debug {
 		log.Printf("in timeBudgetProto.Get(%s)\n", path)
 	}
@@ -31,11 +31,11 @@ func (p timeBudgetProto) Get(path string, oldRc string) {
 	totalTime := time.Since(initial)
 	transferTime := totalTime - latency // Transfer time ends
 
-	reportPerformance(initial, latency, transferTime, []byte(""), path, http.StatusOK, oldRc)
+	reportPerformance(initial, latency, transferTime, 0, path, http.StatusOK, "GET", oldRc, nil, "")
 }
 
 // Put does a PUT that should take one tenth of a second
-func (p timeBudgetProto) Put(path, size, oldRc string) {
+func (p timeBudgetProto) Put(path, size, oldRc string, o options) {
 
 	if conf.Debug {
 		log.Printf("in timeBudgetProto.Put(%s, %s)\n", path, size)
@@ -47,9 +47,9 @@ func (p timeBudgetProto) Put(path, size, oldRc string) {
 	totalTime := time.Since(initial)
 	transferTime := totalTime - latency // Transfer time ends
 
-	reportPerformance(initial, latency, transferTime, []byte(""), path, http.StatusOK, oldRc)
+	reportPerformance(initial, latency, transferTime, 0, path, http.StatusOK, "PUT", oldRc, nil, "")
 }
 
-func (p timeBudgetProto) Post(path, size, oldRC, body string) {
+func (p timeBudgetProto) Post(path, size, oldRC, body string, o options) {
 	log.Fatalf("POST is unimplemented\n")
 }
@@ -0,0 +1,276 @@
+package loadtesting
+
+// Authenticate REST requests. The kinds are
+//	basic   user and password
+//	bearer  a static token, which is re-read if it comes from a file that changes
+//	oauth2  client credentials, refreshed before they expire
+//	hmac    a signature of the request, made with a shared secret
+// Secrets can be given literally, as env:NAME or as file:path, so they
+// needn't appear on command lines or in config files.
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authenticator adds credentials to a request
+type authenticator interface {
+	authenticate(req *http.Request) error
+}
+
+var auth authenticator // nil unless --auth was given
+
+// newAuthenticator makes the authenticator that cfg asks for, or nil for none
+func newAuthenticator(cfg Config) (authenticator, error) {
+	switch cfg.Auth {
+	case "", "none":
+		return nil, nil
+	case "basic":
+		password, err := readSecret(cfg.AuthSecret)
+		if err != nil {
+			return nil, err
+		}
+		return basicAuth{user: cfg.AuthUser, password: password}, nil
+	case "bearer":
+		if cfg.AuthSecret == "" {
+			return nil, fmt.Errorf("bearer authentication needs a token")
+		}
+		b := &bearerAuth{source: cfg.AuthSecret}
+		return b, b.load()
+	case "oauth2":
+		if cfg.AuthTokenURL == "" || cfg.AuthUser == "" {
+			return nil, fmt.Errorf("oauth2 authentication needs a token url and a client id")
+		}
+		secret, err := readSecret(cfg.AuthSecret)
+		if err != nil {
+			return nil, err
+		}
+		return &oauth2Auth{tokenURL: cfg.AuthTokenURL, clientID: cfg.AuthUser,
+			clientSecret: secret, scopes: cfg.AuthScopes, client: &http.Client{Timeout: 30 * time.Second}}, nil
+	case "hmac":
+		secret, err := readSecret(cfg.AuthSecret)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.AuthUser == "" || secret == "" {
+			return nil, fmt.Errorf("hmac authentication needs a key id and a secret")
+		}
+		return hmacAuth{keyID: cfg.AuthUser, secret: []byte(secret)}, nil
+	}
+	return nil, fmt.Errorf("unknown authentication %q, expected basic, bearer, oauth2 or hmac", cfg.Auth)
+}
+
+// readSecret reads a literal, env:NAME or file:path secret
+func readSecret(source string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(source, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return source, nil
+}
+
+// basicAuth does http basic authentication
+type basicAuth struct {
+	user, password string
+}
+
+func (a basicAuth) authenticate(req *http.Request) error {
+	req.SetBasicAuth(a.user, a.password)
+	return nil
+}
+
+// bearerAuth sends a static token. If the token is in a file, it is
+// re-read when the file changes, checking at most once a second, so
+// something else can renew it during a long test
+type bearerAuth struct {
+	source  string
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	checked time.Time
+}
+
+func (a *bearerAuth) authenticate(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if strings.HasPrefix(a.source, "file:") && time.Since(a.checked) > time.Second {
+		if err := a.reload(); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// load reads the token for the first time
+func (a *bearerAuth) load() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.reload()
+}
+
+// reload re-reads the token if its file has changed. Call it with mu held.
+func (a *bearerAuth) reload() error {
+	a.checked = time.Now()
+	if name := strings.TrimPrefix(a.source, "file:"); name != a.source {
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Equal(a.modTime) && a.token != "" {
+			return nil
+		}
+		a.modTime = info.ModTime()
+	}
+	token, err := readSecret(a.source)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("bearer token from %s is empty", a.source)
+	}
+	a.token = token
+	return nil
+}
+
+// oauth2Auth gets tokens with the OAuth2 client-credentials grant, and
+// gets a new one shortly before the old one expires
+type oauth2Auth struct {
+	tokenURL, clientID, clientSecret, scopes string
+	client                                   *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// refreshMargin is how long before expiry a token is replaced
+const refreshMargin = 60 * time.Second
+
+func (a *oauth2Auth) authenticate(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" || (!a.expiry.IsZero() && time.Until(a.expiry) < refreshMargin) {
+		if err := a.refresh(); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// refresh gets a new token. Call it with mu held.
+func (a *oauth2Auth) refresh() error {
+	var reply struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if a.scopes != "" {
+		form.Set("scope", a.scopes)
+	}
+	req, err := http.NewRequest("POST", a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+	start := time.Now()
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not get an oauth2 token, %v", err)
+	}
+	defer resp.Body.Close() // nolint
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read the oauth2 token, %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth2 token request returned %d, %q", resp.StatusCode, body)
+	}
+	if err = json.Unmarshal(body, &reply); err != nil || reply.AccessToken == "" {
+		return fmt.Errorf("oauth2 token reply %q had no access_token, %v", body, err)
+	}
+	a.token = reply.AccessToken
+	a.expiry = time.Time{}
+	if reply.ExpiresIn > 0 {
+		a.expiry = start.Add(time.Duration(reply.ExpiresIn) * time.Second)
+	}
+	if conf.Debug {
+		log.Printf("got an oauth2 token, expiring at %s\n", a.expiry)
+	}
+	return nil
+}
+
+// hmacAuth signs requests with HMAC-SHA256. The string signed is
+//
+//	method \n path?query \n date \n sha256 of body
+//
+// where the body hash is UNSIGNED-PAYLOAD for bodies that are streamed,
+// like PUTs. The date and body hash are sent as X-Date and
+// X-Content-Sha256, and the signature as
+//
+//	Authorization: HMAC-SHA256 keyid:base64-signature
+type hmacAuth struct {
+	keyID  string
+	secret []byte
+	now    func() time.Time // the clock, time.Now unless testing
+}
+
+func (a hmacAuth) authenticate(req *http.Request) error {
+	payload := "UNSIGNED-PAYLOAD"
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		if _, err = io.Copy(h, body); err != nil {
+			return err
+		}
+		payload = hex.EncodeToString(h.Sum(nil))
+	} else if req.Body == nil || req.Body == http.NoBody {
+		payload = hex.EncodeToString(sha256.New().Sum(nil))
+	}
+	now := time.Now
+	if a.now != nil {
+		now = a.now
+	}
+	date := now().UTC().Format(http.TimeFormat)
+	req.Header.Set("X-Date", date)
+	req.Header.Set("X-Content-Sha256", payload)
+	req.Header.Set("Authorization", "HMAC-SHA256 "+a.keyID+":"+a.sign(req.Method, req.URL.RequestURI(), date, payload))
+	return nil
+}
+
+// sign returns the base64 signature of a request's parts
+func (a hmacAuth) sign(method, uri, date, payload string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + date + "\n" + payload)) // nolint
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,134 @@
+package loadtesting
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBasicAndBearerAuth checks the static kinds, and that a token
+// file is re-read when it changes
+func TestBasicAndBearerAuth(t *testing.T) {
+	t.Setenv("LOADTEST_PASSWORD", "p:w d")
+	a, err := newAuthenticator(Config{Auth: "basic", AuthUser: "dave", AuthSecret: "env:LOADTEST_PASSWORD"})
+	if err != nil {
+		t.Fatalf("newAuthenticator(basic) failed, %v", err)
+	}
+	req := httptest.NewRequest("GET", "/a", nil)
+	if err = a.authenticate(req); err != nil {
+		t.Fatalf("basic authenticate failed, %v", err)
+	}
+	if user, password, ok := req.BasicAuth(); !ok || user != "dave" || password != "p:w d" {
+		t.Errorf("basic auth sent %q, %q, %v", user, password, ok)
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err = os.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err = newAuthenticator(Config{Auth: "bearer", AuthSecret: "file:" + tokenFile})
+	if err != nil {
+		t.Fatalf("newAuthenticator(bearer) failed, %v", err)
+	}
+	a.authenticate(req) // nolint
+	if got := req.Header.Get("Authorization"); got != "Bearer first" {
+		t.Errorf("bearer sent %q, want Bearer first", got)
+	}
+	if err = os.WriteFile(tokenFile, []byte("second\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(tokenFile, later, later)                    // nolint
+	a.(*bearerAuth).checked = time.Now().Add(-time.Minute) // as if a second had passed
+	a.authenticate(req)                                    // nolint
+	if got := req.Header.Get("Authorization"); got != "Bearer second" {
+		t.Errorf("bearer sent %q after the file changed, want Bearer second", got)
+	}
+
+	if _, err = newAuthenticator(Config{Auth: "kerberos"}); err == nil {
+		t.Errorf("newAuthenticator accepted an unknown kind")
+	}
+}
+
+// TestOAuth2Refresh checks that a token is reused until it is about
+// to expire, and then replaced
+func TestOAuth2Refresh(t *testing.T) {
+	var issued int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if r.FormValue("grant_type") != "client_credentials" || id != "app" || secret != "s3cret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		issued++
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "bearer", "expires_in": 3600}`, issued)
+	}))
+	defer server.Close()
+
+	a, err := newAuthenticator(Config{Auth: "oauth2", AuthUser: "app", AuthSecret: "s3cret",
+		AuthTokenURL: server.URL})
+	if err != nil {
+		t.Fatalf("newAuthenticator(oauth2) failed, %v", err)
+	}
+	tests := []struct {
+		expiresIn time.Duration // before the request, zero to leave it alone
+		want      string
+	}{
+		{0, "Bearer token-1"},
+		{0, "Bearer token-1"},
+		{time.Hour, "Bearer token-1"},
+		{refreshMargin / 2, "Bearer token-2"},
+		{0, "Bearer token-2"},
+	}
+	for i, test := range tests {
+		if test.expiresIn != 0 {
+			a.(*oauth2Auth).expiry = time.Now().Add(test.expiresIn)
+		}
+		req := httptest.NewRequest("GET", "/a", nil)
+		if err = a.authenticate(req); err != nil {
+			t.Fatalf("request %d, authenticate failed, %v", i, err)
+		}
+		if got := req.Header.Get("Authorization"); got != test.want {
+			t.Errorf("request %d sent %q, want %q", i, got, test.want)
+		}
+	}
+
+	a, _ = newAuthenticator(Config{Auth: "oauth2", AuthUser: "app", AuthSecret: "wrong",
+		AuthTokenURL: server.URL})
+	if err = a.authenticate(httptest.NewRequest("GET", "/a", nil)); err == nil {
+		t.Errorf("authenticate succeeded with a bad client secret")
+	}
+}
+
+// TestHMACAuth checks a signed POST against a signature worked out
+// independently, for a fixed time
+func TestHMACAuth(t *testing.T) {
+	a, err := newAuthenticator(Config{Auth: "hmac", AuthUser: "key1", AuthSecret: "shh"})
+	if err != nil {
+		t.Fatalf("newAuthenticator(hmac) failed, %v", err)
+	}
+	h, ok := a.(hmacAuth)
+	if !ok {
+		t.Fatalf("newAuthenticator(hmac) made a %T", a)
+	}
+	h.now = func() time.Time { return time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC) }
+	req, _ := http.NewRequest("POST", "http://example.com/items?x=1", strings.NewReader(`{"a": 1}`))
+	if err = h.authenticate(req); err != nil {
+		t.Fatalf("hmac authenticate failed, %v", err)
+	}
+	want := map[string]string{
+		"X-Date":           "Wed, 01 May 2024 10:00:00 GMT",
+		"X-Content-Sha256": "f9d86028c6e0d64e225186f96acb69338b2c59764df79162107f5c4bb34d1310",
+		"Authorization":    "HMAC-SHA256 key1:k4UIaOHwxBvUco3WuK5gxU9U/A5IWlFEZeMbEOxndBI=",
+	}
+	for header, value := range want {
+		if got := req.Header.Get(header); got != value {
+			t.Errorf("hmac sent %s %q, want %q", header, got, value)
+		}
+	}
+}
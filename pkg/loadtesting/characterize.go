@@ -0,0 +1,287 @@
+package loadtesting
+
+// characterize fits a workload model to a log, for synthesize to make
+// bigger or longer workloads of the same shape with keys of their own,
+// instead of replaying the same hour of cache-friendly keys faster.
+// It fits the mix of operations and codes, the popularity of objects as
+// a zipf curve, their sizes for each operation, the arrival rate over
+// time and the shape of the gaps between arrivals, and how fast new
+// objects join the working set.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// fitQuantiles is how many intervals empirical distributions have
+const fitQuantiles = 20
+
+// fitRecord is what we need of a record
+type fitRecord struct {
+	at   time.Time
+	op   string
+	path string
+	size float64
+	code string
+}
+
+// Characterize reads a log and writes a model of its workload to stdout
+func Characterize(f *os.File, filename string, cfg Config) {
+	conf = cfg
+	if conf.Debug {
+		log.Printf("in Characterize(f *os.File, filename=%s)\n", filename)
+	}
+	r, err := newRecordReader(conf.Format, f)
+	if err != nil {
+		log.Fatalf("Fatal error reading %s: %s, halting\n", filename, err)
+	}
+	records, err := readFitRecords(r, filename)
+	if err != nil {
+		log.Fatalf("Fatal error reading %s: %s, halting\n", filename, err)
+	}
+	m, err := characterize(records, conf.FitStep, conf.FitPaths)
+	if err != nil {
+		log.Fatalf("Fatal error fitting a model to %s: %s, halting\n", filename, err)
+	}
+	text, _ := json.MarshalIndent(m, "", "\t") // nolint, can't fail
+	fmt.Printf("%s\n", text)
+}
+
+// readFitRecords reads the records we can model, in time order
+func readFitRecords(r recordReader, filename string) ([]fitRecord, error) {
+	var records []fitRecord
+	skipped := make(map[string]int)
+
+	for recNo := 1; ; recNo++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("record %d of %s ignored: %s\n", recNo, filename, err)
+			continue
+		}
+		if len(record) <= operatorField {
+			log.Printf("record %d of %s, %q, ignored: too few fields\n", recNo, filename, record)
+			continue
+		}
+		op := record[operatorField]
+		if op == "DELE" {
+			op = "DELETE"
+		}
+		if !synthOps[op] {
+			skipped[op]++
+			continue
+		}
+		at, err := recordTime(record[dateField], record[timeField])
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", recNo, err)
+		}
+		size, _ := strconv.ParseFloat(record[bytesField], 64)
+		records = append(records, fitRecord{at: at, op: op, path: record[pathField],
+			size: size, code: record[returnCodeField]})
+	}
+	for op, n := range skipped {
+		log.Printf("%d %s records in %s can't be modelled, and were ignored\n", n, op, filename)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%d GETs, PUTs or DELETEs are too few to fit", len(records))
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].at.Before(records[j].at) })
+	return records, nil
+}
+
+// characterize fits a model to records in time order, with rates for
+// each step of time, and objects with paths like paths, or /synthetic/
+// and a number
+func characterize(records []fitRecord, step time.Duration, paths string) (*workloadModel, error) {
+	if step <= 0 {
+		step = time.Minute
+	}
+	m := &workloadModel{
+		Start: records[0].at.Format("2006-01-02 15:04:05"),
+		Ops:   make(map[string]float64),
+		Sizes: make(map[string]sizeDistribution),
+		Codes: make(map[string]map[string]float64),
+	}
+
+	// the mix, and the objects, with the size they were first seen with
+	counts := make(map[string]int) // requests for each object
+	var sizes = make(map[string][]float64)
+	var objectRequests, lateRequests, firstSeenLate int
+	extensions := make(map[string]int)
+	for i, r := range records {
+		m.Ops[r.op]++
+		if m.Codes[r.op] == nil {
+			m.Codes[r.op] = make(map[string]float64)
+		}
+		m.Codes[r.op][r.code]++
+
+		rc, _ := strconv.Atoi(r.code)
+		if _, create := codeDescr(rc); r.op == "DELETE" || (r.op == "GET" && !create) {
+			// synthesize gives these paths of their own
+			continue
+		}
+		late := i >= len(records)/2
+		objectRequests++
+		if late {
+			lateRequests++
+		}
+		if counts[r.path] == 0 {
+			sizes[r.op] = append(sizes[r.op], r.size)
+			extensions[path.Ext(r.path)]++
+			if late {
+				firstSeenLate++
+			}
+		}
+		counts[r.path]++
+	}
+	total := float64(len(records))
+	for op, n := range m.Ops {
+		m.Ops[op] = roundTo(n/total, 4)
+		for code, c := range m.Codes[op] {
+			m.Codes[op][code] = roundTo(c/n, 4)
+		}
+	}
+	for op := range m.Ops {
+		if op != "DELETE" {
+			m.Sizes[op] = fitDistribution(sizes[op])
+		}
+	}
+
+	// the working set grows by the objects first seen in the second
+	// half, as the first is all new. The rest were there at the start.
+	if lateRequests > 0 {
+		m.NewObjects = roundTo(float64(firstSeenLate)/float64(lateRequests), 4)
+	}
+	m.Objects = max(len(counts)-int(m.NewObjects*float64(objectRequests)), 1)
+	m.Popularity = fitZipf(counts)
+
+	if paths == "" {
+		paths = "/synthetic/%d" + commonest(extensions)
+	}
+	m.Paths = paths
+	m.Rates, m.Arrivals = fitArrivals(records, step)
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fitDistribution makes an empirical distribution of values, or a fixed
+// one if they're all the same
+func fitDistribution(values []float64) sizeDistribution {
+	if len(values) == 0 {
+		return sizeDistribution{Kind: "fixed"}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	if sorted[0] == sorted[len(sorted)-1] {
+		return sizeDistribution{Kind: "fixed", Value: sorted[0]}
+	}
+	q := make([]float64, fitQuantiles+1)
+	for i := range q {
+		// interpolate, so the quantiles of a few values are still in order
+		x := float64(i) / fitQuantiles * float64(len(sorted)-1)
+		j := min(int(x), len(sorted)-2)
+		q[i] = roundTo(sorted[j]+(x-float64(j))*(sorted[j+1]-sorted[j]), 6)
+	}
+	return sizeDistribution{Kind: "empirical", Quantiles: q}
+}
+
+// fitZipf fits a zipf curve to the requests for each object, by least
+// squares on the log of the count against the log of the rank. The
+// tail of objects seen once or twice is left out, as it's flattened by
+// the log being too short to see how rare they really are.
+func fitZipf(counts map[string]int) popularity {
+	var ranked []int
+	for _, n := range counts {
+		ranked = append(ranked, n)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ranked)))
+
+	var n, sx, sy, sxx, sxy float64
+	for i, c := range ranked {
+		if c < 3 {
+			break
+		}
+		x, y := math.Log(float64(i+1)), math.Log(float64(c))
+		n++
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	if n < 2 || n*sxx == sx*sx {
+		return popularity{Kind: "uniform"}
+	}
+	s := -(n*sxy - sx*sy) / (n*sxx - sx*sx)
+	if s < 0.05 {
+		return popularity{Kind: "uniform"}
+	}
+	return popularity{Kind: "zipf", S: roundTo(s, 3)}
+}
+
+// fitArrivals finds the rate in each step of time, and the shape of the
+// gaps between arrivals, as a multiple of the mean gap at the time
+func fitArrivals(records []fitRecord, step time.Duration) ([]rateStep, sizeDistribution) {
+	start := records[0].at
+	length := records[len(records)-1].at.Sub(start).Seconds()
+	// and the gap after the last, on average
+	length += length / float64(len(records)-1)
+	if length == 0 {
+		length = 1
+	}
+
+	// a short last step is part of the one before
+	steps := max(int(math.Round(length/step.Seconds())), 1)
+	counts := make([]int, steps)
+	for _, r := range records {
+		counts[min(int(r.at.Sub(start)/step), steps-1)]++
+	}
+	rates := make([]rateStep, steps)
+	for i, n := range counts {
+		seconds := step.Seconds()
+		if i == steps-1 {
+			seconds = length - float64(i)*step.Seconds()
+		}
+		rates[i] = rateStep{Seconds: roundTo(seconds, 6), Rate: roundTo(float64(n)/seconds, 6)}
+	}
+
+	var gaps []float64
+	for i := 1; i < len(records); i++ {
+		gap := records[i].at.Sub(records[i-1].at).Seconds()
+		gaps = append(gaps, gap*rates[min(int(records[i-1].at.Sub(start)/step), steps-1)].Rate)
+	}
+	arrivals := fitDistribution(gaps)
+	if arrivals.Kind == "fixed" && arrivals.Value == 0 {
+		// all at once, which synthesize can't do
+		arrivals.Value = 1
+	}
+	return rates, arrivals
+}
+
+// commonest is the commonest key of a count map, the first if tied
+func commonest(counts map[string]int) string {
+	var best string
+	for _, k := range sortedKeys(counts) {
+		if counts[k] > counts[best] {
+			best = k
+		}
+	}
+	return best
+}
+
+// roundTo rounds x to so many significant digits, so models are readable
+func roundTo(x float64, digits int) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(x, 'g', digits, 64), 64)
+	return v
+}
@@ -0,0 +1,123 @@
+package loadtesting
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCharacterize fits a model to a synthesized log, which should get
+// back the model it came from, and scales it up with new keys
+func TestCharacterize(t *testing.T) {
+	growing := strings.Replace(model, `"objects": 1000,`, `"objects": 1000, "newObjects": 0.05,`, 1)
+	m, err := readModel(strings.NewReader(growing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var perf bytes.Buffer
+	if err = synthesize(m, &perf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := readFitRecords(newPerfReader(&perf), "perf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fitted, err := characterize(records, time.Minute, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for op, want := range m.Ops {
+		if got := fitted.Ops[op]; math.Abs(got-want) > 0.01 {
+			t.Errorf("%s is %.3f of the mix, want %.3f", op, got, want)
+		}
+	}
+	if got := fitted.Codes["GET"]["404"]; math.Abs(got-0.05) > 0.01 {
+		t.Errorf("%.3f of GETs are 404s, want 0.05", got)
+	}
+	if p := fitted.Popularity; p.Kind != "zipf" || math.Abs(p.S-1.1) > 0.15 {
+		t.Errorf("popularity is %+v, want zipf with an s of about 1.1", p)
+	}
+	if got := fitted.NewObjects; math.Abs(got-0.05) > 0.01 {
+		t.Errorf("%.3f of requests are for new objects, want 0.05", got)
+	}
+	if n := len(fitted.Rates); n != 10 {
+		t.Errorf("got %d rates, want one a minute, %+v", n, fitted.Rates)
+	}
+	if r := fitted.Rates[len(fitted.Rates)-1].Rate; math.Abs(r-100) > 5 {
+		t.Errorf("the last rate is %g, want 100", r)
+	}
+	if median := fitted.Sizes["PUT"].Quantiles[fitQuantiles/2]; math.Abs(median-1500) > 100 {
+		t.Errorf("the median PUT is %g bytes, want 1500", median)
+	}
+	// Poisson arrivals, so exponential gaps, with a median of ln 2
+	if median := fitted.Arrivals.Quantiles[fitQuantiles/2]; math.Abs(median-math.Ln2) > 0.1 {
+		t.Errorf("the median gap is %.3f of the mean, want %.3f", median, math.Ln2)
+	}
+
+	// it's a model file synthesize can read, and scale
+	text, err := json.Marshal(fitted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigger := mustSynthesize(t, string(text), 10, 0)
+	if n := float64(len(bigger)) / float64(len(records)); n < 9.5 || n > 10.5 {
+		t.Errorf("ten times the rate made %.2f times the records", n)
+	}
+	objects := make(map[string]int)
+	for _, r := range bigger {
+		if !strings.HasPrefix(r[pathField], "/synthetic/") || !strings.Contains(r[pathField], ".jpg") {
+			t.Fatalf("%s is not one of the new keys", r[pathField])
+		}
+		objects[r[pathField]]++
+	}
+	// the working set grows with the requests
+	if n := len(objects); n < 20000 {
+		t.Errorf("ten times the requests used %d objects, want 5%% of them to be new", n)
+	}
+}
+
+// TestFitDistribution checks the quantiles of a few values and of many
+func TestFitDistribution(t *testing.T) {
+	many := make([]float64, 1001)
+	for i := range many {
+		many[i] = float64(1000 - i)
+	}
+	tests := []struct {
+		name   string
+		values []float64
+		want   sizeDistribution
+	}{
+		{"none", nil, sizeDistribution{Kind: "fixed"}},
+		{"same", []float64{7, 7, 7}, sizeDistribution{Kind: "fixed", Value: 7}},
+		{"two", []float64{10, 0}, sizeDistribution{Kind: "empirical"}},
+		{"many", many, sizeDistribution{Kind: "empirical"}},
+	}
+	for _, test := range tests {
+		got := fitDistribution(test.values)
+		if got.Kind != test.want.Kind || got.Value != test.want.Value {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+			continue
+		}
+		if got.Kind != "empirical" {
+			continue
+		}
+		q := got.Quantiles
+		sorted := append([]float64(nil), test.values...)
+		sort.Float64s(sorted)
+		if len(q) != fitQuantiles+1 || !sort.Float64sAreSorted(q) ||
+			q[0] != sorted[0] || q[fitQuantiles] != sorted[len(sorted)-1] {
+			t.Errorf("%s: got quantiles %v, want %d from min to max, in order", test.name, q, fitQuantiles+1)
+		}
+		if err := got.check(); err != nil {
+			t.Errorf("%s: synthesize wouldn't accept %+v, %v", test.name, got, err)
+		}
+	}
+	if q := fitDistribution(many).Quantiles; q[fitQuantiles/2] != 500 {
+		t.Errorf("the median of 0..1000 is %g", q[fitQuantiles/2])
+	}
+}
@@ -0,0 +1,209 @@
+package loadtesting
+
+// Read the access logs of AWS load balancers, CloudFront and S3 as perf
+// records, so our real object traffic can be replayed.
+//
+// ALB looks like
+//	http 2018-07-02T22:23:00.186641Z app/my-lb/50dc 192.168.1.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/a.jpg HTTP/1.1" "curl/7.46.0" ...
+// classic ELB is the same, without the leading type.
+// CloudFront looks like a "#Fields:" header, followed by tab-separated
+//	2019-12-04	21:02:31	LAX1	392	192.0.2.100	GET	d111.cloudfront.net	/index.html	200	...
+// S3 server access logs look like
+//	79a5 awsexamplebucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 79a5 3E57 REST.GET.OBJECT photos/a.jpg "GET /awsexamplebucket1/photos/a.jpg HTTP/1.1" 200 - 113 113 70 10 ...
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logReader satisfies recordReader for line-oriented logs. Its convert
+// func turns the fields of one line into a perf record, or into nil for
+// lines that aren't requests.
+type logReader struct {
+	r       *csv.Reader
+	convert func(fields []string) ([]string, error)
+}
+
+// Read returns the next request as a perf record
+func (l *logReader) Read() ([]string, error) {
+	for {
+		fields, err := l.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		record, err := l.convert(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%v in %q", err, fields)
+		}
+		if record != nil {
+			return record, nil
+		}
+	}
+}
+
+// FieldPos reports the line of the last request read
+func (l *logReader) FieldPos(field int) (line, column int) {
+	return l.r.FieldPos(field)
+}
+
+// newLogReader makes a csv reader for a log with the given separator,
+// tolerating the odd quoting in user-agents and urls
+func newLogReader(f io.Reader, separator rune, convert func([]string) ([]string, error)) *logReader {
+	r := csv.NewReader(f)
+	r.Comma = separator
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	return &logReader{r: r, convert: convert}
+}
+
+// newALBReader reads ALB and classic ELB access logs. The latency is
+// the sum of the load-balancer and target processing times
+func newALBReader(f io.Reader) *logReader {
+	return newLogReader(f, ' ', func(fields []string) ([]string, error) {
+		if len(fields) > 0 {
+			if _, err := time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+				// ALB lines start with a type, like "http" or "h2"
+				fields = fields[1:]
+			}
+		}
+		// now: time elb client target req_time target_time resp_time
+		// elb_code target_code received sent "request" ...
+		if len(fields) < 12 {
+			return nil, fmt.Errorf("%d fields instead of at least 12", len(fields))
+		}
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return nil, err
+		}
+		var latency float64
+		for _, s := range fields[4:7] {
+			v, _ := strconv.ParseFloat(s, 64)
+			if v > 0 { // -1 means the target didn't answer
+				latency += v
+			}
+		}
+		rc, err := strconv.Atoi(fields[7])
+		if err != nil {
+			return nil, fmt.Errorf("bad status code %q", fields[7])
+		}
+		sent, _ := strconv.ParseInt(fields[10], 10, 64)
+		method, path, err := splitRequestLine(fields[11])
+		if err != nil {
+			return nil, err
+		}
+		return makeRecord(t, latency, 0, sent, path, rc, method, ""), nil
+	})
+}
+
+// newCloudFrontReader reads CloudFront standard logs, using the
+// "#Fields:" header to find the columns. The latency is the time to
+// first byte, and the rest of time-taken is the transfer time
+func newCloudFrontReader(f io.Reader) *logReader {
+	columns := map[string]int{}
+	return newLogReader(f, '\t', func(fields []string) ([]string, error) {
+		if len(fields) == 0 {
+			return nil, nil
+		}
+		if strings.HasPrefix(fields[0], "#Fields:") {
+			for i, name := range strings.Fields(strings.TrimPrefix(fields[0], "#Fields:")) {
+				columns[name] = i
+			}
+			return nil, nil
+		}
+		if strings.HasPrefix(fields[0], "#") {
+			return nil, nil
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("no #Fields: line before the first request")
+		}
+		get := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(fields) || fields[i] == "-" {
+				return ""
+			}
+			return fields[i]
+		}
+
+		t, err := time.Parse("2006-01-02 15:04:05", get("date")+" "+get("time"))
+		if err != nil {
+			return nil, err
+		}
+		rc, _ := strconv.Atoi(get("sc-status"))
+		if rc == 0 {
+			// 000 means the viewer closed the connection, like nginx's 499
+			rc = 499
+		}
+		bytes, _ := strconv.ParseInt(get("sc-bytes"), 10, 64)
+		taken, _ := strconv.ParseFloat(get("time-taken"), 64)
+		latency, err := strconv.ParseFloat(get("time-to-first-byte"), 64)
+		if err != nil || latency > taken {
+			latency = taken
+		}
+		path := get("cs-uri-stem")
+		if q := get("cs-uri-query"); q != "" {
+			path += "?" + q
+		}
+		return makeRecord(t, latency, taken-latency, bytes, path, rc, get("cs-method"), ""), nil
+	})
+}
+
+// newS3LogReader reads S3 server access logs. Only object operations are
+// kept, and the path is the object key, so the result can be replayed with
+// the s3 protocol against a test bucket. The latency is S3's turn-around
+// time and the rest of its total time is the transfer time. PUTs are
+// given the object size, everything else the bytes sent.
+func newS3LogReader(f io.Reader) *logReader {
+	return newLogReader(f, ' ', func(fields []string) ([]string, error) {
+		// owner bucket [time zone] remote requester id operation key
+		// "request" status error bytes_sent object_size total turn-around ...
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("%d fields instead of at least 16", len(fields))
+		}
+		t, err := time.Parse("[02/Jan/2006:15:04:05 -0700]", fields[2]+" "+fields[3])
+		if err != nil {
+			return nil, err
+		}
+		operation := strings.Split(fields[7], ".") // eg, REST.GET.OBJECT
+		if len(operation) != 3 || operation[0] != "REST" || operation[2] != "OBJECT" || fields[8] == "-" {
+			return nil, nil // not something we replay
+		}
+		key, err := url.PathUnescape(fields[8])
+		if err != nil {
+			return nil, fmt.Errorf("bad key %q", fields[8])
+		}
+		rc, err := strconv.Atoi(fields[10])
+		if err != nil {
+			return nil, fmt.Errorf("bad status code %q", fields[10])
+		}
+		bytes, _ := strconv.ParseInt(fields[12], 10, 64)
+		if operation[1] == "PUT" {
+			bytes, _ = strconv.ParseInt(fields[13], 10, 64)
+		}
+		total, _ := strconv.ParseFloat(fields[14], 64)
+		turnAround, _ := strconv.ParseFloat(fields[15], 64)
+		if turnAround > total {
+			turnAround = total
+		}
+		return makeRecord(t, turnAround/1000, (total-turnAround)/1000, bytes,
+			key, rc, operation[1], ""), nil
+	})
+}
+
+// splitRequestLine splits "GET http://host:80/path?q HTTP/1.1" into
+// the method and the path with its query
+func splitRequestLine(line string) (string, string, error) {
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return "", "", fmt.Errorf("bad request line %q", line)
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return "", "", fmt.Errorf("bad url in request line %q", line)
+	}
+	return parts[0], u.RequestURI(), nil
+}
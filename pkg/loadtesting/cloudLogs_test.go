@@ -0,0 +1,47 @@
+package loadtesting
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCloudLogReaders checks one line of each log becomes the expected perf record
+func TestCloudLogReaders(t *testing.T) {
+	var tests = []struct {
+		format   string
+		input    string
+		expected string // fields joined by |
+	}{
+		{"alb",
+			`http 2018-07-02T22:23:00.186641Z app/my-loadbalancer/50dc6c495c0c9188 192.168.131.39:2817 10.0.0.1:80 0.000 0.001 0.000 200 200 34 366 "GET http://www.example.com:80/a.jpg?v=2 HTTP/1.1" "curl/7.46.0" - - arn:aws:elasticloadbalancing:us-east-2:123456789012:targetgroup/my-targets/73e2d6bc24d8a067 "Root=1-58337262-36d228ad5d99923122bbe354" "-" "-" 0 2018-07-02T22:22:48.364000Z "forward" "-" "-" "10.0.0.1:80" "200" "-" "-"` + "\n",
+			"2018-07-02|22:23:00.186|0.001|0|0|366|/a.jpg?v=2|200|GET|"},
+		{"elb",
+			`2015-05-13T23:39:43.945958Z my-loadbalancer 192.168.131.39:2817 10.0.0.1:80 0.000073 0.001048 0.000057 404 404 0 29 "PUT http://www.example.com:80/b HTTP/1.1" "curl/7.38.0" - -` + "\n",
+			"2015-05-13|23:39:43.945|0.001178|0|0|29|/b|404|PUT|"},
+		{"cloudfront",
+			"#Version: 1.0\n" +
+				"#Fields: date time x-edge-location sc-bytes c-ip cs-method cs(Host) cs-uri-stem sc-status cs(Referer) cs(User-Agent) cs-uri-query cs(Cookie) x-edge-result-type x-edge-request-id x-host-header cs-protocol cs-bytes time-taken x-forwarded-for ssl-protocol ssl-cipher x-edge-response-result-type cs-protocol-version fle-status fle-encrypted-fields c-port time-to-first-byte\n" +
+				"2019-12-04\t21:02:31\tLAX1\t392\t192.0.2.100\tGET\td111111abcdef8.cloudfront.net\t/index.html\t200\t-\tMozilla\tlang=en\t-\tHit\tSOX4\td111111abcdef8.cloudfront.net\thttps\t23\t0.5\t-\tTLSv1.2\tECDHE\tHit\tHTTP/2.0\t-\t-\t11040\t0.125\n",
+			"2019-12-04|21:02:31.000|0.125|0.375|0|392|/index.html?lang=en|200|GET|"},
+		{"s3log",
+			"79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:38 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be 3E57427F3EXAMPLE REST.GET.VERSIONING - \"GET /awsexamplebucket1?versioning HTTP/1.1\" 200 - 113 - 7 - \"-\" \"S3Console/0.4\" - s9lzHYrFp76ZVxRcpX9+5cjAnEH2ROuNkd2BHfIa6UkFVdtjf5mKR3/eTPFvsiP/XV/VLi31234= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2\n" +
+				"79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be awsexamplebucket1 [06/Feb/2019:00:00:39 +0000] 192.0.2.3 79a59df900b949e55d96a1e698fbacedfd6e09d98eacf8f8d5218e7cd47ef2be 891CE47D2EXAMPLE REST.PUT.OBJECT photos/my%20cat.jpg \"PUT /awsexamplebucket1/photos/my%20cat.jpg HTTP/1.1\" 200 - - 4096 40 10 \"-\" \"S3Console/0.4\" - Xk+/4Wd3= SigV4 ECDHE-RSA-AES128-GCM-SHA256 AuthHeader awsexamplebucket1.s3.us-west-1.amazonaws.com TLSV1.2\n",
+			"2019-02-06|00:00:39.000|0.01|0.03|0|4096|photos/my cat.jpg|200|PUT|"},
+	}
+	for _, test := range tests {
+		r, err := newRecordReader(test.format, strings.NewReader(test.input))
+		if err != nil {
+			t.Fatalf("%s: newRecordReader failed, %v", test.format, err)
+		}
+		record, err := r.Read()
+		if err != nil {
+			t.Fatalf("%s: Read failed, %v", test.format, err)
+		}
+		if got := strings.Join(record, "|"); got != test.expected {
+			t.Errorf("%s: got\n%s\nexpected\n%s", test.format, got, test.expected)
+		}
+		if err = checkRecord(record); err != nil {
+			t.Errorf("%s: record %q is malformed, %v", test.format, record, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package loadtesting
+
+// content makes the bytes of the files and objects we write, so that
+// mkLoadTestFiles, PUTs and GETs all agree on what a path of a given
+// size contains, without storing it anywhere. It's a splitmix64
+// sequence seeded from the FNV-1a hash of the path and size, so it's
+// the same on every run and every machine, and no two objects share
+// blocks for storage to dedup.
+//
+// Random data doesn't compress, which flatters storage that compresses
+// inline, and is unlike most real data. The compressible kind uses
+// only 16 letters, so it compresses to about half its size.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"strconv"
+)
+
+// contentKinds are the kinds of content we can make, the first the default
+var contentKinds = []string{"random", "compressible"}
+
+// compressibleLetters are the bytes compressible content is made of
+const compressibleLetters = "etaoinshrdlucmfw"
+
+// contentReader reads the content of one object
+type contentReader struct {
+	state        uint64  // of the splitmix64 sequence
+	left         int64   // bytes still to read
+	compressible bool    // letters instead of bytes
+	buf          [8]byte // the current word
+	n            int     // and how much of it is unread
+}
+
+// checkContent returns an error if kind isn't one of contentKinds
+func checkContent(kind string) error {
+	if kind == "" {
+		return nil
+	}
+	for _, k := range contentKinds {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("content %q is not random or compressible", kind)
+}
+
+// newContent returns a reader of the size bytes of content for path
+func newContent(path string, size int64) io.Reader {
+	h := fnv.New64a()
+	io.WriteString(h, path)                            // nolint, can't fail
+	io.WriteString(h, " "+strconv.FormatInt(size, 10)) // nolint
+	return &contentReader{
+		state:        h.Sum64(),
+		left:         size,
+		compressible: conf.Content == "compressible",
+	}
+}
+
+// Read fills p with the next of the content
+func (c *contentReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	i := 0
+	for i < len(p) {
+		if c.n == 0 && len(p)-i >= 8 {
+			// a whole word at a time, when we can
+			binary.LittleEndian.PutUint64(p[i:], c.next())
+			i += 8
+			continue
+		}
+		if c.n == 0 {
+			binary.LittleEndian.PutUint64(c.buf[:], c.next())
+			c.n = 8
+		}
+		m := copy(p[i:], c.buf[8-c.n:])
+		c.n -= m
+		i += m
+	}
+	if c.compressible {
+		for j := range p {
+			p[j] = compressibleLetters[p[j]&0xf]
+		}
+	}
+	c.left -= int64(len(p))
+	return len(p), nil
+}
+
+// next is the next word of the splitmix64 sequence
+func (c *contentReader) next() uint64 {
+	c.state += 0x9e3779b97f4a7c15
+	z := c.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// contentChecksum is the sha256 of the content for path
+func contentChecksum(path string, size int64) string {
+	h := sha256.New()
+	io.Copy(h, newContent(path, size)) // nolint, can't fail
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyContent compares r with the content for path, of the size
+// we got. It's false if they differ, or r fails.
+func verifyContent(path string, size int64, r io.Reader) bool {
+	want := newContent(path, size)
+	got := make([]byte, 32*1024)
+	expected := make([]byte, len(got))
+	for {
+		n, err := io.ReadFull(r, got)
+		if n > 0 {
+			if _, err := io.ReadFull(want, expected[:n]); err != nil {
+				return false // we got more than size
+			}
+			if string(got[:n]) != string(expected[:n]) {
+				return false
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// all of it, if want is empty too
+			_, err = want.Read(expected[:1])
+			return err == io.EOF
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// verifyAnnotation reports whether a body was what was written, if
+// we're verifying
+func verifyAnnotation(path string, rc int, body []byte) string {
+	if !conf.Verify || rc != 200 {
+		return ""
+	}
+	return verifyResult(path, verifyContent(path, int64(len(body)), bytes.NewReader(body)))
+}
+
+// verifyResult annotates a verification, and complains about failures
+func verifyResult(path string, ok bool) string {
+	if ok {
+		return " verify=ok"
+	}
+	log.Printf("the content of %s is not what was written\n", path)
+	if conf.Crash {
+		log.Fatalf("halting.\n")
+	}
+	return " verify=failed"
+}
@@ -0,0 +1,112 @@
+package loadtesting
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// TestContent checks that content is the same however it's read, differs
+// between paths and sizes, and compresses or not as asked
+func TestContent(t *testing.T) {
+	savedConf := conf
+	defer func() { conf = savedConf }()
+
+	for _, kind := range contentKinds {
+		conf = Config{Content: kind}
+		whole, _ := io.ReadAll(newContent("/a.jpg", 100000))
+		if len(whole) != 100000 {
+			t.Fatalf("%s: got %d bytes, want 100000", kind, len(whole))
+		}
+		// a byte at a time gets the same bytes
+		bytewise, _ := io.ReadAll(iotest.OneByteReader(newContent("/a.jpg", 100000)))
+		if !bytes.Equal(whole, bytewise) {
+			t.Errorf("%s: reading a byte at a time got different content", kind)
+		}
+		other, _ := io.ReadAll(newContent("/b.jpg", 100000))
+		if bytes.Equal(whole[:64], other[:64]) {
+			t.Errorf("%s: /a.jpg and /b.jpg start the same", kind)
+		}
+		shorter, _ := io.ReadAll(newContent("/a.jpg", 99999))
+		if bytes.Equal(whole[:64], shorter[:64]) {
+			t.Errorf("%s: sizes 100000 and 99999 start the same", kind)
+		}
+
+		var zipped bytes.Buffer
+		w := gzip.NewWriter(&zipped)
+		w.Write(whole) // nolint
+		w.Close()      // nolint
+		ratio := float64(zipped.Len()) / float64(len(whole))
+		switch {
+		case kind == "random" && ratio < 0.99:
+			t.Errorf("random content compressed to %.2f of its size", ratio)
+		case kind == "compressible" && (ratio < 0.4 || ratio > 0.65):
+			t.Errorf("compressible content compressed to %.2f of its size, want about half", ratio)
+		}
+	}
+}
+
+// TestVerifyContent checks that only the content of the path and size
+// passes verification
+func TestVerifyContent(t *testing.T) {
+	good, _ := io.ReadAll(newContent("/a.jpg", 5000))
+	changed := append([]byte{}, good...)
+	changed[4000] ^= 1
+
+	tests := []struct {
+		name string
+		body []byte
+		size int64
+		want bool
+	}{
+		{"good", good, 5000, true},
+		{"changed", changed, 5000, false},
+		{"short", good[:4999], 5000, false},
+		{"long", append(append([]byte{}, good...), 'x'), 5000, false},
+		{"empty", nil, 0, true},
+	}
+	for _, test := range tests {
+		if got := verifyContent("/a.jpg", test.size, bytes.NewReader(test.body)); got != test.want {
+			t.Errorf("%s: verifyContent gave %v, want %v", test.name, got, test.want)
+		}
+	}
+	if got := contentChecksum("/a.jpg", 0); got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("checksum of nothing is %s, want sha256 of nothing", got)
+	}
+}
+
+// TestVerifyGets puts objects and gets them back with -verify, over
+// REST and S3
+func TestVerifyGets(t *testing.T) {
+	s, err := newSimulator(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	savedConf := conf
+	defer func() { conf = savedConf }()
+	conf = Config{Verify: true, Content: "compressible"}
+	p := RestProto{prefix: server.URL}
+
+	captureStdout(t, func() { p.Put("a.jpg", "20000", "201", options{}) })
+	if out := captureStdout(t, func() { p.Get("a.jpg", "200", options{}) }); !strings.HasSuffix(out, " verify=ok\n") {
+		t.Errorf("REST GET of what was PUT printed %q, want verify=ok", out)
+	}
+	// the simulator makes up content for things it doesn't have
+	if out := captureStdout(t, func() { p.Get("b.jpg", "200", options{}) }); !strings.HasSuffix(out, " verify=failed\n") {
+		t.Errorf("REST GET of something never PUT printed %q, want verify=failed", out)
+	}
+
+	withS3(t, Config{}, func(url string) {
+		conf.Verify = true
+		captureStdout(t, func() { op.Put("a.jpg", "20000", "201", options{}) })
+		if out := captureStdout(t, func() { op.Get("a.jpg", "200", options{}) }); !strings.HasSuffix(out, " verify=ok\n") {
+			t.Errorf("S3 GET of what was PUT printed %q, want verify=ok", out)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package loadtesting
+
+// Convert load-test scripts and results between formats, such as
+// a browser's HAR capture to perf, our perf results to HAR, or
+// JMeter results to one-second samples.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Convert reads records in one format and writes them to stdout in another.
+// baseURL is used to make full urls, for formats that require them.
+func Convert(f *os.File, filename, from, to, baseURL string, cfg Config) {
+	conf = cfg
+	if conf.Debug {
+		log.Printf("in Convert(f *os.File, filename=%s, from=%s, to=%s, baseURL=%s)\n",
+			filename, from, to, baseURL)
+	}
+	r, err := newRecordReader(from, f)
+	if err != nil {
+		log.Fatalf("Fatal error reading %s: %s, halting\n", filename, err)
+	}
+	w, err := newRecordWriter(to, os.Stdout, baseURL)
+	if err != nil {
+		log.Fatalf("Fatal error preparing output: %s, halting\n", err)
+	}
+	for recNo := 1; ; recNo++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("record %d of %s ignored: %s\n", recNo, filename, err)
+			continue
+		}
+		if err = w.Write(record); err != nil {
+			log.Printf("record %d of %s, %q, ignored: %s\n", recNo, filename, record, err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		log.Fatalf("Fatal error writing %s output: %s, halting\n", to, err)
+	}
+}
+
+// secondsWriter satisfies recordWriter by averaging records over each
+// second, like scripts/perf2seconds, optionally for each path separately.
+// Records may arrive out of order, so nothing is written until Close.
+type secondsWriter struct {
+	w       io.Writer
+	byPath  bool
+	samples map[secondsKey]*secondsSample
+}
+
+type secondsKey struct {
+	second time.Time
+	path   string
+}
+
+type secondsSample struct {
+	latency, xferTime, sleepTime float64
+	bytes                        int64
+	requests, errors             int
+}
+
+// newSecondsWriter makes a secondsWriter
+func newSecondsWriter(w io.Writer, byPath bool) *secondsWriter {
+	return &secondsWriter{w: w, byPath: byPath, samples: make(map[secondsKey]*secondsSample)}
+}
+
+// Write adds a record to the sample for its second
+func (s *secondsWriter) Write(r []string) error {
+	if len(r) < 9 {
+		return fmt.Errorf("%d fields instead of at least 9", len(r))
+	}
+	t, err := recordTime(r[dateField], r[timeField])
+	if err != nil {
+		return err
+	}
+	key := secondsKey{second: t.Truncate(time.Second)}
+	if s.byPath {
+		key.path = r[pathField]
+	}
+	sample, ok := s.samples[key]
+	if !ok {
+		sample = &secondsSample{}
+		s.samples[key] = sample
+	}
+	latency, _ := strconv.ParseFloat(r[latencyField], 64)
+	xferTime, _ := strconv.ParseFloat(r[transferTimeField], 64)
+	sleepTime, _ := strconv.ParseFloat(r[sleepTimeField], 64)
+	bytes, _ := strconv.ParseInt(r[bytesField], 10, 64)
+	sample.latency += latency
+	sample.xferTime += xferTime
+	sample.sleepTime += sleepTime
+	sample.bytes += bytes
+	sample.requests++
+	// a 201, 204 or 304 is a success too
+	if rc, err := strconv.Atoi(r[returnCodeField]); err != nil || rc >= 400 {
+		sample.errors++
+	}
+	return nil
+}
+
+// Close writes the averages, in time order
+func (s *secondsWriter) Close() error {
+	keys := make([]secondsKey, 0, len(s.samples))
+	for k := range s.samples {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].second.Equal(keys[j].second) {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].second.Before(keys[j].second)
+	})
+
+	header := "#date time latency xfertime sleeptime bytes requests errors"
+	if s.byPath {
+		header += " path"
+	}
+	if _, err := fmt.Fprintln(s.w, header); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		sample := s.samples[k]
+		n := float64(sample.requests)
+		line := fmt.Sprintf("%s %f %f %f %d %d %d",
+			k.second.Format("2006-01-02 15:04:05"),
+			sample.latency/n, sample.xferTime/n, sample.sleepTime/n,
+			sample.bytes/int64(sample.requests), sample.requests, sample.errors)
+		if s.byPath {
+			line += " " + strconv.Quote(k.path)
+		}
+		if _, err := fmt.Fprintln(s.w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
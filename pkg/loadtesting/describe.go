@@ -0,0 +1,308 @@
+package loadtesting
+
+// Describe a load-test script in "perf" format before using it: find the
+// malformed records and summarize the workload they represent.
+// input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 404 GET"
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLinesShown limits how many line numbers we list for any one warning
+const maxLinesShown = 10
+
+// knownOperators are the operations that may appear in a perf file
+var knownOperators = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true, "DELE": true, "HEAD": true,
+	"OPEN": true, "SEND": true, "EXPECT": true, "CLOSE": true, // websockets
+}
+
+// workload accumulates what we learn from a perf file
+type workload struct {
+	records   int
+	malformed int
+	undated   int
+	ops       map[string]int
+	codes     map[int]int
+	paths     map[string]bool
+	perMinute map[time.Time]int
+	skipped   map[string][]int // operator to line numbers
+	sizes     []float64
+	latencies []float64
+	xferTimes []float64
+	traced    map[string][]float64 // dns= etc, from runLoadTest --trace
+	reused    int
+}
+
+// Describe reads a perf file, reporting malformed records and a summary of
+// the workload to stdout. It returns the number of malformed records.
+func Describe(f *os.File, filename string, cfg Config) int {
+	conf = cfg
+	if conf.Debug {
+		log.Printf("in Describe(f *os.File, filename=%s)\n", filename)
+	}
+	w := &workload{
+		ops:       make(map[string]int),
+		codes:     make(map[int]int),
+		paths:     make(map[string]bool),
+		perMinute: make(map[time.Time]int),
+		skipped:   make(map[string][]int),
+		traced:    make(map[string][]float64),
+	}
+
+	r, err := newRecordReader(conf.Format, f)
+	if err != nil {
+		log.Fatalf("Fatal error reading %s: %s, halting\n", filename, err)
+	}
+	fmt.Printf("# malformed records in %s\n", filename)
+	for recNo := 1; ; recNo++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line := lineOf(r)
+		if line == 0 {
+			line = recNo
+		}
+		if err != nil {
+			// the csv reader reports its own line numbers, and can continue
+			fmt.Printf("%s\n", err)
+			w.malformed++
+			continue
+		}
+		if err = checkRecord(record); err != nil {
+			fmt.Printf("line %d: %s: %q\n", line, err, record)
+			w.malformed++
+			continue
+		}
+		w.add(record, line)
+	}
+	w.report(filename)
+	return w.malformed
+}
+
+// checkRecord returns an error if a record can't be replayed
+func checkRecord(r []string) error {
+	if len(r) < 9 {
+		return fmt.Errorf("%d fields instead of at least 9", len(r))
+	}
+	for _, i := range []int{latencyField, transferTimeField, sleepTimeField} {
+		if _, err := strconv.ParseFloat(r[i], 64); err != nil {
+			return fmt.Errorf("field %d, %q, is not a time in seconds", i+1, r[i])
+		}
+	}
+	if _, err := strconv.ParseInt(r[bytesField], 10, 64); err != nil {
+		return fmt.Errorf("size %q is not a number", r[bytesField])
+	}
+	if r[pathField] == "" {
+		return fmt.Errorf("path is empty")
+	}
+	if _, err := strconv.Atoi(r[returnCodeField]); err != nil {
+		return fmt.Errorf("return code %q is not a number", r[returnCodeField])
+	}
+	if !knownOperators[r[operatorField]] {
+		return fmt.Errorf("operator %q is unknown", r[operatorField])
+	}
+	if r[operatorField] == "POST" && (len(r) <= bodyField || r[bodyField] == "") {
+		return fmt.Errorf("POST has no body")
+	}
+	return nil
+}
+
+// add a well-formed record to the workload
+func (w *workload) add(r []string, line int) {
+	w.records++
+	w.ops[r[operatorField]]++
+	rc, _ := strconv.Atoi(r[returnCodeField])
+	w.codes[rc]++
+	w.paths[r[pathField]] = true
+	size, _ := strconv.ParseFloat(r[bytesField], 64)
+	w.sizes = append(w.sizes, size)
+	latency, _ := strconv.ParseFloat(r[latencyField], 64)
+	w.latencies = append(w.latencies, latency)
+	xferTime, _ := strconv.ParseFloat(r[transferTimeField], 64)
+	w.xferTimes = append(w.xferTimes, xferTime)
+
+	t, err := recordTime(r[dateField], r[timeField])
+	if err != nil {
+		w.undated++
+	} else {
+		w.perMinute[t.Truncate(time.Minute)]++
+	}
+	if !willDo(r[operatorField]) {
+		w.skipped[r[operatorField]] = append(w.skipped[r[operatorField]], line)
+	}
+	w.addTrace(r)
+}
+
+// addTrace collects the timing columns of a runLoadTest result, which
+// follow the operator, and perhaps a rate or body
+func (w *workload) addTrace(r []string) {
+	for i := bodyField; i < len(r); i++ {
+		key, value, found := strings.Cut(r[i], "=")
+		if !found {
+			continue
+		}
+		if key == "reused" {
+			if value == "true" {
+				w.reused++
+			}
+			continue
+		}
+		for _, column := range traceColumns {
+			if key == column {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					w.traced[key] = append(w.traced[key], v)
+				}
+			}
+		}
+	}
+}
+
+// report prints the summary
+func (w *workload) report(filename string) {
+	fmt.Printf("# %d records, %d malformed\n", w.records, w.malformed)
+	if w.records == 0 {
+		return
+	}
+
+	fmt.Printf("# operations\n")
+	for _, key := range sortedKeys(w.ops) {
+		fmt.Printf("%s %d %.1f%%\n", key, w.ops[key], percent(w.ops[key], w.records))
+	}
+
+	fmt.Printf("# return codes\n")
+	codes := make([]int, 0, len(w.codes))
+	for rc := range w.codes {
+		codes = append(codes, rc)
+	}
+	sort.Ints(codes)
+	for _, rc := range codes {
+		fmt.Printf("%d %d %.1f%% %s\n", rc, w.codes[rc], percent(w.codes[rc], w.records),
+			codeMap[rc].descr)
+	}
+
+	fmt.Printf("# sizes in bytes\n")
+	printDistribution("bytes", w.sizes)
+	printSizeBuckets(w.sizes)
+
+	fmt.Printf("# unique paths\n")
+	fmt.Printf("%d of %d records, %.1f%% repeated\n", len(w.paths), w.records,
+		100-percent(len(w.paths), w.records))
+
+	fmt.Printf("# recorded request rate, per minute\n")
+	if w.undated > 0 {
+		fmt.Printf("%d records have unrecognized dates and are not counted\n", w.undated)
+	}
+	minutes := make([]time.Time, 0, len(w.perMinute))
+	for t := range w.perMinute {
+		minutes = append(minutes, t)
+	}
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i].Before(minutes[j]) })
+	for _, t := range minutes {
+		fmt.Printf("%s %d %.2f requests/second\n", t.Format("2006-01-02 15:04"),
+			w.perMinute[t], float64(w.perMinute[t])/60)
+	}
+
+	fmt.Printf("# recorded latency and transfer time percentiles, in seconds\n")
+	printDistribution("latency", w.latencies)
+	printDistribution("xfertime", w.xferTimes)
+	if len(w.traced) > 0 {
+		fmt.Printf("# traced times, in seconds\n")
+		for _, column := range traceColumns {
+			if len(w.traced[column]) > 0 {
+				printDistribution(column, w.traced[column])
+			}
+		}
+		if n := len(w.traced["ttfb"]); n > 0 {
+			fmt.Printf("%d of %d connections reused, %.1f%%\n", w.reused, n, percent(w.reused, n))
+		}
+	}
+
+	fmt.Printf("# warnings\n")
+	for _, key := range sortedKeys(w.ops) {
+		lines := w.skipped[key]
+		if len(lines) == 0 {
+			continue
+		}
+		shown := lines
+		if len(shown) > maxLinesShown {
+			shown = shown[:maxLinesShown]
+		}
+		fmt.Printf("%d %s records in %s would be skipped with read = %v, write = %v, "+
+			"at lines %v\n", len(lines), key, filename, conf.R, conf.W, shown)
+	}
+}
+
+// printDistribution prints the min, median, high percentiles and max of some values
+func printDistribution(name string, values []float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	fmt.Printf("#name min p50 p90 p95 p99 max\n")
+	fmt.Printf("%s %g %g %g %g %g %g\n", name, sorted[0],
+		percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 95),
+		percentile(sorted, 99), sorted[len(sorted)-1])
+}
+
+// printSizeBuckets prints sizes in powers-of-ten buckets
+func printSizeBuckets(sizes []float64) {
+	var buckets [8]int
+
+	for _, s := range sizes {
+		i := 0
+		if s >= 1 {
+			i = int(math.Log10(s)) + 1
+		}
+		if i >= len(buckets) {
+			i = len(buckets) - 1
+		}
+		buckets[i]++
+	}
+	fmt.Printf("#less-than count percent\n")
+	for i, n := range buckets {
+		if n == 0 {
+			continue
+		}
+		limit := fmt.Sprintf("%.0f", math.Pow10(i))
+		if i == len(buckets)-1 {
+			limit = "more"
+		}
+		fmt.Printf("%s %d %.1f%%\n", limit, n, percent(n, len(sizes)))
+	}
+}
+
+// percentile returns the p-th percentile of already-sorted values,
+// using the nearest-rank method
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// percent returns n as a percentage of total
+func percent(n, total int) float64 {
+	return 100 * float64(n) / float64(total)
+}
+
+// sortedKeys returns the keys of a count map in order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,41 @@
+package loadtesting
+
+import (
+	"testing"
+)
+
+// TestCheckRecord checks that malformed records are found, and good ones pass
+func TestCheckRecord(t *testing.T) {
+	var tests = []struct {
+		record []string
+		ok     bool
+	}{
+		{[]string{"2017-09-21", "08:15:07.270", "0", "0", "0", "0", "/a.jpg", "200", "GET"}, true},
+		{[]string{"01-Mar-2017", "16:00:00", "0.1", "0.2", "0", "10", "/b", "200", "POST", "{}"}, true},
+		{[]string{"2017-09-21", "08:15:07.270", "0", "0", "0", "0", "/a.jpg", "200"}, false},
+		{[]string{"2017-09-21", "08:15:07.270", "x", "0", "0", "0", "/a.jpg", "200", "GET"}, false},
+		{[]string{"2017-09-21", "08:15:07.270", "0", "0", "0", "big", "/a.jpg", "200", "GET"}, false},
+		{[]string{"2017-09-21", "08:15:07.270", "0", "0", "0", "0", "/a.jpg", "OK", "GET"}, false},
+		{[]string{"2017-09-21", "08:15:07.270", "0", "0", "0", "0", "/a.jpg", "200", "FETCH"}, false},
+		{[]string{"2017-09-21", "08:15:07.270", "0", "0", "0", "0", "/a.jpg", "200", "POST"}, false},
+	}
+	for _, test := range tests {
+		err := checkRecord(test.record)
+		if (err == nil) != test.ok {
+			t.Errorf("checkRecord(%q) = %v, expected ok = %v", test.record, err, test.ok)
+		}
+	}
+}
+
+// TestRecordTime checks the date formats we've seen in perf files
+func TestRecordTime(t *testing.T) {
+	for _, dt := range [][2]string{
+		{"2017-09-21", "08:15:07.270"},
+		{"01-Mar-2017", "16:00:00"},
+		{"09/Nov/2017", "13:12:44"},
+	} {
+		if _, err := recordTime(dt[0], dt[1]); err != nil {
+			t.Errorf("recordTime(%q, %q) failed, %v", dt[0], dt[1], err)
+		}
+	}
+}
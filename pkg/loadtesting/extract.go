@@ -0,0 +1,218 @@
+package loadtesting
+
+// Capture values from responses, so that a replay of a flow like
+// upload-then-fetch uses the ids the server hands out now rather than
+// the stale ones in the recording. A record declares
+//	extract=name:json:path    a value from a JSON body, like item.id or items[0].id
+//	extract=name:regex:expr   the first submatch of expr in the body, or all of the match
+//	extract=name:header:Name  a response header, like Location or ETag
+// and later records use it as ${name}. Records with a session=name option
+// share their own variables, and are run one at a time in the order they
+// were read, so each sees what the previous ones captured. Records without
+// one share the variables of the whole run.
+//
+// Sessions are run in order even without templating, as protocols with
+// long-lived connections, like websockets, need them to be.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// extraction is one extract= option
+type extraction struct {
+	name, kind, expr string
+	re               *regexp.Regexp
+}
+
+// parseExtraction parses name:kind:expression
+func parseExtraction(s string) (extraction, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return extraction{}, fmt.Errorf("extract must look like name:kind:expression")
+	}
+	e := extraction{name: parts[0], kind: parts[1], expr: parts[2]}
+	switch e.kind {
+	case "json", "header":
+	case "regex":
+		re, err := regexp.Compile(e.expr)
+		if err != nil {
+			return extraction{}, fmt.Errorf("bad regex %q, %v", e.expr, err)
+		}
+		e.re = re
+	default:
+		return extraction{}, fmt.Errorf("unknown kind %q, expected json, regex or header", e.kind)
+	}
+	return e, nil
+}
+
+// String returns the extraction as it appears in a record
+func (e extraction) String() string {
+	return e.name + ":" + e.kind + ":" + e.expr
+}
+
+// apply finds the value in a response
+func (e extraction) apply(header http.Header, body []byte) (string, bool) {
+	switch e.kind {
+	case "header":
+		v := header.Get(e.expr)
+		return v, v != ""
+	case "regex":
+		m := e.re.FindSubmatch(body)
+		switch {
+		case m == nil:
+			return "", false
+		case len(m) > 1:
+			return string(m[1]), true
+		}
+		return string(m[0]), true
+	case "json":
+		return jsonPath(body, e.expr)
+	}
+	return "", false
+}
+
+// jsonPath gets a scalar from a JSON document with a path like
+// $.items[0].id, items.0.id or just id
+func jsonPath(body []byte, path string) (string, bool) {
+	var v interface{}
+
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	decoder.UseNumber() // or big ids lose digits
+	if err := decoder.Decode(&v); err != nil {
+		return "", false
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	for _, step := range strings.Split(path, ".") {
+		if step == "" {
+			continue
+		}
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[step]
+		case []interface{}:
+			i, err := strconv.Atoi(step)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false // missing, null, or not a scalar
+}
+
+// varStore is somewhere extracted values can be kept
+type varStore interface {
+	setVar(name, value string)
+}
+
+// extract applies a record's extractions to its response, if there's
+// anywhere to keep the results
+func (o options) extract(path string, header http.Header, body []byte) {
+	if o.store == nil {
+		return
+	}
+	for _, e := range o.extracts {
+		v, ok := e.apply(header, body)
+		if !ok {
+			log.Printf("extract %s found nothing in the response to %s\n", e, path)
+			continue
+		}
+		if conf.Debug {
+			log.Printf("extracted %s = %q from %s\n", e.name, v, path)
+		}
+		o.store.setVar(e.name, v)
+	}
+}
+
+// userSession is the variables of one session, and the turnstile that
+// runs its records in order
+type userSession struct {
+	mu      sync.Mutex
+	turn    *sync.Cond
+	vars    map[string]string
+	next    int64 // ticket for the next record read
+	serving int64 // ticket of the record allowed to run
+}
+
+// sessions are the sessions seen so far, by name
+var sessions = struct {
+	sync.Mutex
+	named map[string]*userSession
+}{named: make(map[string]*userSession)}
+
+// sessionNamed returns the named session, creating it if need be
+func sessionNamed(name string) *userSession {
+	sessions.Lock()
+	defer sessions.Unlock()
+	s, ok := sessions.named[name]
+	if !ok {
+		s = newUserSession()
+		sessions.named[name] = s
+	}
+	return s
+}
+
+// newUserSession makes an empty session
+func newUserSession() *userSession {
+	s := &userSession{vars: make(map[string]string)}
+	s.turn = sync.NewCond(&s.mu)
+	return s
+}
+
+// setVar sets a session variable
+func (s *userSession) setVar(name, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vars[name] = value
+}
+
+// getVar gets a session variable
+func (s *userSession) getVar(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.vars[name]
+	return v, ok
+}
+
+// run runs f after everything passed to run before it has finished.
+// It doesn't wait itself, so a slow session doesn't slow its worker.
+func (s *userSession) run(f func()) {
+	s.mu.Lock()
+	ticket := s.next
+	s.next++
+	s.mu.Unlock()
+
+	go func() {
+		s.mu.Lock()
+		for s.serving != ticket {
+			s.turn.Wait()
+		}
+		s.mu.Unlock()
+
+		defer func() {
+			s.mu.Lock()
+			s.serving++
+			s.mu.Unlock()
+			s.turn.Broadcast()
+		}()
+		f()
+	}()
+}
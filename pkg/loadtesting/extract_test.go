@@ -0,0 +1,163 @@
+package loadtesting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestExtraction checks each kind of extraction against one response
+func TestExtraction(t *testing.T) {
+	header := http.Header{"Location": []string{"/items/42"}, "Etag": []string{`"abc"`}}
+	body := []byte(`{"item": {"id": 42, "tags": ["new", "red"]}, "token": "t-1", "ok": true}`)
+
+	tests := []struct {
+		option, want string
+		ok           bool
+	}{
+		{"id:json:item.id", "42", true},
+		{"id:json:$.item.tags[1]", "red", true},
+		{"id:json:item.tags.0", "new", true},
+		{"id:json:ok", "true", true},
+		{"id:json:item", "", false},
+		{"id:json:missing.id", "", false},
+		{"id:regex:\"token\": \"([^\"]+)\"", "t-1", true},
+		{"id:regex:t-[0-9]", "t-1", true},
+		{"id:regex:absent", "", false},
+		{"id:header:Location", "/items/42", true},
+		{"id:header:etag", `"abc"`, true},
+		{"id:header:X-Missing", "", false},
+	}
+	for _, test := range tests {
+		e, err := parseExtraction(test.option)
+		if err != nil {
+			t.Errorf("parseExtraction(%q) failed, %v", test.option, err)
+			continue
+		}
+		got, ok := e.apply(header, body)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%q found %q, %v, want %q, %v", test.option, got, ok, test.want, test.ok)
+		}
+	}
+
+	for _, bad := range []string{"id:json", "id:xpath:/a", ":json:id", "id:regex:("} {
+		if _, err := parseExtraction(bad); err == nil {
+			t.Errorf("parseExtraction(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+// TestSessionFlow replays an upload-then-fetch flow, where the fetch
+// must use the id the upload was given
+func TestSessionFlow(t *testing.T) {
+	var mu sync.Mutex
+	var fetched string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			time.Sleep(20 * time.Millisecond) // so a racing GET would go first
+			w.Header().Set("Location", "items/"+r.URL.Query().Get("name"))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "new-1"}`)) // nolint
+			return
+		}
+		mu.Lock()
+		fetched = r.URL.Path
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	savedConf, savedOp, savedPipe, savedTemplates := conf, op, pipe, templates
+	defer func() { conf, op, pipe, templates = savedConf, savedOp, savedPipe, savedTemplates }()
+	defer expanding.Store(expanding.Load())
+	expanding.Store(false) // extracting turns it on
+	conf = Config{R: true}
+	done := make(chan string)
+	op = finishingOp{operation: RestProto{prefix: server.URL}, done: done}
+	pipe = make(chan []string, 2)
+	var err error
+	templates, err = newTemplater("", "")
+	if err != nil {
+		t.Fatalf("newTemplater failed, %v", err)
+	}
+
+	pipe <- []string{"2024-05-01", "10:00:00", "0", "0", "0", "2", "items?name=a", "201", "POST",
+		"{}", "session=u1", "extract=id:json:id", "extract=where:header:Location"}
+	pipe <- []string{"2024-05-01", "10:00:01", "0", "0", "0", "0", "${where}/${id}", "200", "GET",
+		"", "session=u1"}
+	w := newWorkerState()
+	doOneOperation(w)
+	doOneOperation(w)
+	<-done
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched != "/items/a/new-1" {
+		t.Errorf("fetched %q, want /items/a/new-1", fetched)
+	}
+	if _, ok := templates.getVar("id"); ok {
+		t.Errorf("a session's extraction leaked into the run's variables")
+	}
+}
+
+// TestRunExtraction captures a value from a record without a session,
+// and without --template, for the records after it to use
+func TestRunExtraction(t *testing.T) {
+	var mu sync.Mutex
+	var fetched string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id": "new-2"}`)) // nolint
+			return
+		}
+		mu.Lock()
+		fetched = r.URL.Path
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	savedConf, savedOp, savedPipe, savedTemplates := conf, op, pipe, templates
+	defer func() { conf, op, pipe, templates = savedConf, savedOp, savedPipe, savedTemplates }()
+	defer expanding.Store(expanding.Load())
+	expanding.Store(false)
+	conf = Config{R: true}
+	done := make(chan string)
+	op = finishingOp{operation: RestProto{prefix: server.URL}, done: done}
+	pipe = make(chan []string, 2)
+	templates, _ = newTemplater("", "")
+
+	pipe <- []string{"2024-05-01", "10:00:00", "0", "0", "0", "2", "items", "201", "POST",
+		"{}", "extract=id:json:id"}
+	pipe <- []string{"2024-05-01", "10:00:01", "0", "0", "0", "0", "items/${id}", "200", "GET"}
+	w := newWorkerState()
+	// records without a session don't wait for each other, so we do
+	doOneOperation(w)
+	<-done
+	doOneOperation(w)
+	<-done
+	mu.Lock()
+	defer mu.Unlock()
+	if fetched != "/items/new-2" {
+		t.Errorf("fetched %q, want /items/new-2", fetched)
+	}
+}
+
+// finishingOp is an operation that says when each Get or Post is done
+type finishingOp struct {
+	operation
+	done chan string
+}
+
+// Get gets, then says so
+func (f finishingOp) Get(path, oldRc string, o options) {
+	f.operation.Get(path, oldRc, o)
+	f.done <- path
+}
+
+// Post posts, then says so
+func (f finishingOp) Post(path, size, oldRc, body string, o options) {
+	f.operation.Post(path, size, oldRc, body, o)
+	f.done <- path
+}
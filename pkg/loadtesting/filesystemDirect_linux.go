@@ -0,0 +1,6 @@
+package loadtesting
+
+import "syscall"
+
+// oDirect bypasses the page cache
+const oDirect = syscall.O_DIRECT
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package loadtesting
+
+// oDirect is not available, so --fs-direct is refused
+const oDirect = 0
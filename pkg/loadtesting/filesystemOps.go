@@ -1,86 +1,317 @@
 package loadtesting
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unsafe"
 )
 
-// FilesystemProto exists for the convenience of callers only
+// FilesystemProto satisfies operation by reading and writing files
+// under a root directory, to measure a disk without a server in front
+// of it. GETs read a file through, PUTs write the record's size in
+// bytes and DELETEs unlink. Paths can't escape the root.
 type FilesystemProto struct {
-	//empty
+	root string
 }
 
-// Init does nothing
-func (p FilesystemProto) Init() {}
+// defaultReadBuffer is the size of each read, without --fs-buffer
+const defaultReadBuffer = 128 * 1024
 
-// Get too does nothing
-func (p FilesystemProto) Get(path, size, oldRC string) error {
-	return nil
+// directAlign is the alignment O_DIRECT needs of buffers, offsets and sizes
+const directAlign = 4096
+
+// fsBuffers are pointers to the read and write buffers, made anew by
+// each Init so none are left over of another size or alignment
+var fsBuffers *sync.Pool
+
+// Init checks the root is a directory, as the disk may not be mounted,
+// and makes the buffers
+func (p FilesystemProto) Init() {
+	if conf.Debug {
+		log.Printf("in FilesystemProto.Init(), root=%s\n", p.root)
+	}
+	if conf.FSDirect && oDirect == 0 {
+		log.Fatalf("direct i/o is not supported on this system, halting\n")
+	}
+	fi, err := os.Stat(p.dir())
+	if err != nil {
+		log.Fatalf("could not find root directory %s, is it mounted? %v\n", p.dir(), err)
+	}
+	if !fi.IsDir() {
+		log.Fatalf("root %s is not a directory, halting\n", p.dir())
+	}
+
+	size := conf.FSBufferSize
+	if size <= 0 {
+		size = defaultReadBuffer
+	}
+	if conf.FSDirect {
+		size = (size + directAlign - 1) / directAlign * directAlign
+	}
+	fsBuffers = &sync.Pool{New: func() interface{} {
+		// a pointer, as putting a slice in the pool would allocate
+		b := alignedBuffer(size)
+		return &b
+	}}
 }
 
-// Put unconditionally creates filesystem files
-func (p FilesystemProto) Put(path, size, oldRC string) error {
-	return fmt.Errorf("put is not implemented yet")
-	//i, err := strconv.ParseInt(size, 10, 64)
-	//if err != nil {
-	//	log.Fatalf("could not parse size %q, halting\n", size)
-	//}
-	//return TimedCreateFilesystemFile(path, i)
+// dir is the root directory, without any file:// in front of it
+func (p FilesystemProto) dir() string {
+	root := strings.TrimPrefix(p.root, "file://")
+	if root == "" {
+		root = "."
+	}
+	return root
 }
 
-// TimedCreateFilesystemFile is for local (non-Protocol) file creation
-func TimedCreateFilesystemFile(fullPath string, size int64) error {
-	initial := time.Now() //               Response time starts
-	mustCreateFilesystemFile(fullPath, size)
-	responseTime := time.Since(initial) // Response time ends
-	//fmt.Printf("%s %f 0 0 %d %s 201 PUT\n",
-	//	initial.Format("2006-01-02 15:04:05.000"),
-	//	responseTime.Seconds(), size, fullPath)
-	reportPerformance(initial, responseTime, 0, nil, fullPath, 201, "")
+// fullPath puts path under the root, ignoring any .. that would leave it
+func (p FilesystemProto) fullPath(path string) string {
+	return filepath.Join(p.dir(), filepath.Clean("/"+path))
+}
 
-	return nil
+// Get reads a file to the end. The latency is the time to open it and
+// get the first buffer-full, the transfer time that to read the rest.
+// With --verify, it's read again afterwards to check the content.
+func (p FilesystemProto) Get(path, oldRc string, o options) {
+	if conf.Debug {
+		log.Printf("in FilesystemProto.Get(%s)\n", path)
+	}
+	pooled := fsBuffers.Get().(*[]byte)
+	defer fsBuffers.Put(pooled)
+	buf := *pooled
+
+	initial := time.Now() // Response time starts
+	f, err := openFile(p.fullPath(path), os.O_RDONLY, 0)
+	if err != nil {
+		p.report(initial, time.Since(initial), 0, 0, path, fsErrorCode(err), "GET", oldRc, "", err)
+		return
+	}
+	defer f.Close() // nolint
+	n, err := f.Read(buf)
+	latency := time.Since(initial) // Latency ends
+	size := int64(n)
+	for err == nil {
+		n, err = f.Read(buf)
+		size += int64(n)
+	}
+	transferTime := time.Since(initial) - latency // Transfer time ends
+	if err != io.EOF {
+		p.report(initial, latency, transferTime, size, path, http.StatusInternalServerError, "GET", oldRc, "", err)
+		return
+	}
+	p.report(initial, latency, transferTime, size, path, http.StatusOK, "GET", oldRc, p.verify(path, size), nil)
+}
 
+// verify annotates whether a file has the content for path, if we're
+// verifying
+func (p FilesystemProto) verify(path string, size int64) string {
+	if !conf.Verify {
+		return ""
+	}
+	f, err := os.Open(p.fullPath(path))
+	if err != nil {
+		return verifyResult(path, false)
+	}
+	defer f.Close() // nolint
+	return verifyResult(path, verifyContent(path, size, f))
 }
 
-// mustCreateFilesystemFile implements making the file in a filesystem relative to the current directory
-// It's used by both local and s3.
-func mustCreateFilesystemFile(fullPath string, size int64) {
+// Put creates or replaces a file of size bytes, creating directories
+// as needed. With --fs-sync the latency includes an fsync, whose time
+// is also reported as fsync=.
+func (p FilesystemProto) Put(path, size, oldRc string, o options) {
+	var syncTime time.Duration
+	var annotation string
+
 	if conf.Debug {
-		log.Printf("in createFilesystemFile(%s, %d)\n", fullPath, size)
+		log.Printf("in FilesystemProto.Put(%s, %s)\n", path, size)
 	}
-	dir := path.Dir(fullPath)
-	err := os.MkdirAll(dir, os.ModePerm)
+	n, err := strconv.ParseInt(size, 10, 64)
 	if err != nil {
-		log.Fatalf("could not create directories of %q, %v", fullPath, err)
+		p.report(time.Now(), 0, 0, 0, path, http.StatusBadRequest, "PUT", oldRc, "",
+			fmt.Errorf("could not parse size %q", size))
+		return
 	}
-	out, err := os.Create(fullPath)
+	name := p.fullPath(path)
+
+	initial := time.Now() // Response time starts
+	if err = os.MkdirAll(filepath.Dir(name), os.ModePerm); err == nil {
+		err = writeFile(name, path, n)
+	}
+	if err == nil && conf.FSSync {
+		start := time.Now()
+		err = syncFile(name)
+		syncTime = time.Since(start)
+		annotation = fmt.Sprintf(" fsync=%f", syncTime.Seconds())
+	}
+	latency := time.Since(initial) // Response time ends
 	if err != nil {
-		log.Fatalf("could not create file %q, %v", fullPath, err)
+		p.report(initial, latency, 0, 0, path, fsErrorCode(err), "PUT", oldRc, annotation, err)
+		return
 	}
-	if size == 0 {
-		err = out.Close()
-		if err != nil {
-			log.Fatalf("error closing zero-size  %q, %v", fullPath, err)
+	p.report(initial, latency, 0, n, path, http.StatusCreated, "PUT", oldRc, annotation, nil)
+}
+
+// Post is not meaningful for files
+func (p FilesystemProto) Post(path, size, oldRc, body string, o options) {
+	log.Fatalf("POST is not meaningful for a filesystem, halting\n")
+}
+
+// Delete unlinks a file
+func (p FilesystemProto) Delete(path, oldRc string, o options) {
+	if conf.Debug {
+		log.Printf("in FilesystemProto.Delete(%s)\n", path)
+	}
+	initial := time.Now() // Response time starts
+	err := os.Remove(p.fullPath(path))
+	latency := time.Since(initial) // Response time ends
+	if err != nil {
+		p.report(initial, latency, 0, 0, path, fsErrorCode(err), "DELETE", oldRc, "", err)
+		return
+	}
+	p.report(initial, latency, 0, 0, path, http.StatusNoContent, "DELETE", oldRc, "", nil)
+}
+
+// report prints the result of an operation, and any unexpected error
+func (p FilesystemProto) report(initial time.Time, latency, transferTime time.Duration, size int64,
+	path string, rc int, operator, oldRc, annotation string, err error) {
+	if err != nil && (conf.Verbose || rc != http.StatusNotFound) {
+		log.Printf("filesystem %s %s failed, %v\n", operator, path, err)
+		if conf.Crash {
+			log.Fatalf("halting.\n")
 		}
-	} else {
-		// Fill it with random data, inefficiently. Hoist.
-		in, err := os.Open("/dev/urandom")
-		if err != nil {
-			log.Fatalf("could not open /dev/urandom, %v", err)
+	}
+	reportPerformance(initial, latency, transferTime, size, path, rc, operator, oldRc, nil, annotation)
+}
+
+// openFile opens a file, bypassing the page cache with --fs-direct
+func openFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if conf.FSDirect {
+		flag |= oDirect
+	}
+	return os.OpenFile(name, flag, perm)
+}
+
+// writeFile writes the size bytes of content for path to name. Direct
+// i/o can only write whole blocks, so any partial one at the end is
+// written through the cache.
+func writeFile(name, path string, size int64) error {
+	pooled := fsBuffers.Get().(*[]byte)
+	defer fsBuffers.Put(pooled)
+	buf := *pooled
+	content := newContent(path, size)
+
+	f, err := openFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	whole := size
+	if conf.FSDirect {
+		whole = size / directAlign * directAlign
+	}
+	for written := int64(0); written < whole; {
+		chunk := buf
+		if whole-written < int64(len(chunk)) {
+			chunk = chunk[:whole-written]
 		}
-		defer in.Close()
-		_, err = io.CopyN(out, in, size)
+		io.ReadFull(content, chunk) // nolint, can't fail
+		n, err := f.Write(chunk)
+		written += int64(n)
 		if err != nil {
-			log.Fatalf("could not copy %d bytes to %q, %v", size, fullPath, err)
+			f.Close() // nolint
+			return err
 		}
+	}
+	if err = f.Close(); err != nil || whole == size {
+		return err
+	}
 
-		err = out.Close()
-		if err != nil {
-			log.Fatalf("error closing %q, %v", fullPath, err)
-		}
+	f, err = os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	tail := buf[:size-whole]
+	io.ReadFull(content, tail) // nolint, can't fail
+	if _, err = f.WriteAt(tail, whole); err != nil {
+		f.Close() // nolint
+		return err
+	}
+	return f.Close()
+}
+
+// syncFile flushes a file to the disk
+func syncFile(name string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close() // nolint
+		return err
+	}
+	return f.Close()
+}
+
+// fsErrorCode maps a filesystem error onto an http code
+func fsErrorCode(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	case errors.Is(err, syscall.ENOSPC):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// alignedBuffer makes a buffer that direct i/o can use
+func alignedBuffer(size int) []byte {
+	b := make([]byte, size+directAlign)
+	off := directAlign - int(uintptr(unsafe.Pointer(&b[0]))&(directAlign-1))
+	if off == directAlign {
+		off = 0
+	}
+	return b[off : off+size]
+}
+
+// TimedCreateFilesystemFile is for local (non-Protocol) file creation,
+// with the content for path, see content.go
+func TimedCreateFilesystemFile(fullPath, path string, size int64) error {
+	initial := time.Now() //               Response time starts
+	err := writeContent(fullPath, path, size)
+	responseTime := time.Since(initial) // Response time ends
+	if err != nil {
+		return err
+	}
+	reportPerformance(initial, responseTime, 0, size, fullPath, http.StatusCreated, "PUT", "", nil, "")
+
+	return nil
+
+}
+
+// writeContent creates a file with the content for path
+func writeContent(fullPath, path string, size int64) error {
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(fullPath)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, newContent(path, size)); err != nil {
+		out.Close() // nolint
+		return fmt.Errorf("could not write %d bytes to %q, %v", size, fullPath, err)
 	}
+	return out.Close()
 }
@@ -0,0 +1,126 @@
+package loadtesting
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestFilesystemOps writes, reads and deletes files under a temporary root
+func TestFilesystemOps(t *testing.T) {
+	root := t.TempDir()
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+
+	modes := []Config{
+		{W: true, R: true},
+		{W: true, R: true, FSSync: true, FSBufferSize: 1000},
+		{W: true, R: true, FSDirect: true, FSSync: true},
+	}
+	for _, mode := range modes {
+		conf = mode
+		if conf.FSDirect && !directWorks(root) {
+			t.Logf("skipping direct i/o, which %s does not support", root)
+			continue
+		}
+		op = FilesystemProto{root: root}
+		op.Init()
+
+		rate := strconv.Itoa(ExpectedRate)
+		tests := []struct {
+			operator, path, size string
+			want                 string
+		}{
+			{"PUT", "/a/b/c", "10000", " 10000 /a/b/c 201 PUT"},
+			{"GET", "/a/b/c", "0", " 10000 /a/b/c 200 GET"},
+			{"PUT", "/a/b/c", "4096", " 4096 /a/b/c 201 PUT"},
+			{"GET", "/a/b/c", "0", " 4096 /a/b/c 200 GET"},
+			{"DELETE", "/a/b/c", "0", " /a/b/c 204 DELETE"},
+			{"GET", "/a/b/c", "0", " /a/b/c 404 GET " + rate + " expectedRC=200"},
+			{"DELETE", "/a/b/c", "0", " /a/b/c 404 DELETE"},
+			{"PUT", "/../../escape", "1", " 1 /../../escape 201 PUT"},
+		}
+		for _, test := range tests {
+			out := captureStdout(t, func() {
+				perform([]string{"2024-05-01", "10:00:00", "0", "0", "0", test.size, test.path, "200",
+					test.operator}, options{})
+			})
+			if !strings.Contains(out, test.want) {
+				t.Errorf("%+v: %s %s printed %q, want %q", mode, test.operator, test.path, out, test.want)
+			}
+			if conf.FSSync && test.operator == "PUT" && !strings.Contains(out, " fsync=") {
+				t.Errorf("%+v: PUT printed %q, want an fsync time", mode, out)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(root, "escape")); err != nil {
+			t.Errorf("a path with .. was not kept under the root, %v", err)
+		}
+	}
+}
+
+// TestFilesystemVerify checks that PUTs write, and GETs verify, the
+// same content as mkLoadTestFiles makes
+func TestFilesystemVerify(t *testing.T) {
+	root := t.TempDir()
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+
+	modes := []Config{
+		{W: true, R: true, Verify: true},
+		{W: true, R: true, Verify: true, Content: "compressible", FSBufferSize: 1000},
+		{W: true, R: true, Verify: true, FSDirect: true},
+	}
+	for _, mode := range modes {
+		conf = mode
+		if conf.FSDirect && !directWorks(root) {
+			t.Logf("skipping direct i/o, which %s does not support", root)
+			continue
+		}
+		op = FilesystemProto{root: root}
+		op.Init()
+		do := func(operator, path, size string) string {
+			return captureStdout(t, func() {
+				perform([]string{"2024-05-01", "10:00:00", "0", "0", "0", size, path, "200", operator}, options{})
+			})
+		}
+
+		do("PUT", "/put.jpg", "10000")
+		if err := writeContent(filepath.Join(root, "made.jpg"), "/made.jpg", 5000); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{"/put.jpg", "/made.jpg"} {
+			if out := do("GET", path, "0"); !strings.HasSuffix(out, " 200 GET "+strconv.Itoa(ExpectedRate)+" verify=ok\n") {
+				t.Errorf("%+v: GET %s printed %q, want verify=ok", mode, path, out)
+			}
+		}
+		made, _ := os.ReadFile(filepath.Join(root, "put.jpg"))
+		want, _ := io.ReadAll(newContent("/put.jpg", 10000))
+		if !bytes.Equal(made, want) {
+			t.Errorf("%+v: PUT wrote something other than the content for its path", mode)
+		}
+
+		if err := os.WriteFile(filepath.Join(root, "made.jpg"), make([]byte, 5000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if out := do("GET", "/made.jpg", "0"); !strings.HasSuffix(out, " verify=failed\n") {
+			t.Errorf("%+v: GET of a changed file printed %q, want verify=failed", mode, out)
+		}
+	}
+}
+
+// directWorks is true if files in dir can be opened with O_DIRECT
+func directWorks(dir string) bool {
+	if oDirect == 0 {
+		return false
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "direct"), os.O_CREATE|os.O_WRONLY|oDirect, 0644)
+	if err != nil {
+		return false
+	}
+	f.Close() // nolint
+	return true
+}
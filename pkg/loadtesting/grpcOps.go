@@ -0,0 +1,380 @@
+package loadtesting
+
+// grpcOps implements unary gRPC calls. A record's path is the full method
+// name, like /grpc.health.v1.Health/Check, and its body is the request
+// message in JSON. The messages are built at run time from a descriptor
+// set, made with protoc --include_imports --descriptor_set_out, or from
+// the server's reflection service. The status is reported as the
+// nearest http return code, so the rest of our tools understand it.
+// Streaming methods are not supported yet.
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCProto satisfies operation by making gRPC calls
+type GRPCProto struct {
+	prefix string
+}
+
+var grpcConn *grpc.ClientConn
+var grpcMethods *methodResolver
+
+// Init connects to the server, which is host:port, grpc://host:port
+// or, for TLS, grpcs://host:port, and loads the descriptor set, if any
+func (p GRPCProto) Init() {
+	var err error
+
+	grpcConn, err = newGRPCConn(p.prefix)
+	if err != nil {
+		log.Fatalf("could not set up a grpc connection to %s, %v, halting\n", p.prefix, err)
+	}
+	grpcMethods, err = newMethodResolver(conf.GRPCDescriptors, grpcConn)
+	if err != nil {
+		log.Fatalf("could not load grpc descriptors, %v, halting\n", err)
+	}
+}
+
+// newGRPCConn makes a client connection, using the tls options if it's grpcs
+func newGRPCConn(prefix string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	target := prefix
+	for _, scheme := range []string{"grpc://", "http://"} {
+		target = strings.TrimPrefix(target, scheme)
+	}
+	for _, scheme := range []string{"grpcs://", "https://"} {
+		if strings.HasPrefix(target, scheme) {
+			target = strings.TrimPrefix(target, scheme)
+			tlsConfig, err := newTLSConfig(conf)
+			if err != nil {
+				return nil, err
+			}
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+	}
+	return grpc.NewClient(strings.TrimSuffix(target, "/"), grpc.WithTransportCredentials(creds))
+}
+
+// Get calls a method with an empty request
+func (p GRPCProto) Get(path string, oldRc string, o options) {
+	p.call("GET", path, "", oldRc, o)
+}
+
+// Put is not meaningful for grpc
+func (p GRPCProto) Put(path, size, oldRC string, o options) {
+	log.Fatalf("PUT is not meaningful for grpc, halting\n")
+}
+
+// Post calls a method with the body as its request
+func (p GRPCProto) Post(path, size, oldRC, body string, o options) {
+	p.call("POST", path, body, oldRC, o)
+}
+
+// call makes one unary call and reports it
+func (p GRPCProto) call(operator, method, body, oldRc string, o options) {
+	var header metadata.MD
+	var size int
+
+	if conf.Debug {
+		log.Printf("in grpc.call(%s, %s, %q)\n", operator, method, body)
+	}
+	method = "/" + strings.TrimPrefix(method, "/")
+	initial := time.Now()
+	md, err := grpcMethods.find(method)
+	if err != nil {
+		p.report(initial, 0, 0, method, codes.Unimplemented, operator, oldRc, err)
+		return
+	}
+	req := dynamicpb.NewMessage(md.Input())
+	if body != "" {
+		if err = protojson.Unmarshal([]byte(body), req); err != nil {
+			p.report(initial, 0, 0, method, codes.InvalidArgument, operator, oldRc,
+				fmt.Errorf("body is not a %s, %v", md.Input().FullName(), err))
+			return
+		}
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), grpcMetadata(o))
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+
+	initial = time.Now() // Response time starts
+	err = grpcConn.Invoke(ctx, method, req, resp, grpc.Header(&header))
+	latency := time.Since(initial) // Response time ends
+	if err == nil {
+		size = proto.Size(resp)
+		if len(o.extracts) > 0 {
+			js, _ := protojson.Marshal(resp)
+			o.extract(method, metadataHeader(header), js)
+		}
+	}
+	p.report(initial, latency, size, method, status.Code(err), operator, oldRc, err)
+}
+
+// report prints the result of a call, and any error
+func (p GRPCProto) report(initial time.Time, latency time.Duration, size int, method string,
+	code codes.Code, operator, oldRc string, err error) {
+	if err != nil && (conf.Verbose || code != codes.NotFound) {
+		log.Printf("grpc %s %s returned %v\n", operator, method, err)
+		if conf.Crash {
+			log.Fatalf("halting.\n")
+		}
+	}
+	reportPerformance(initial, latency, 0, int64(size), method, grpcCodeToHTTPCode(code), operator, oldRc,
+		nil, "")
+}
+
+// grpcMetadata converts a record's headers and --headers into metadata
+func grpcMetadata(o options) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range o.headers {
+		md.Append(key, values...)
+	}
+	for key, value := range conf.HeaderMap {
+		md.Append(key, value)
+	}
+	return md
+}
+
+// metadataHeader converts response metadata into headers, for extract
+func metadataHeader(md metadata.MD) http.Header {
+	h := make(http.Header)
+	for key, values := range md {
+		for _, v := range values {
+			h.Add(key, v)
+		}
+	}
+	return h
+}
+
+// grpcCodeToHTTPCode maps a status onto the http code a gateway would use
+func grpcCodeToHTTPCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // nginx's client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError // Unknown, Internal, DataLoss
+}
+
+// methodResolver finds method descriptors, from a descriptor set or
+// by asking the server
+type methodResolver struct {
+	mu      sync.Mutex
+	files   *protoregistry.Files
+	conn    *grpc.ClientConn // for reflection, nil if we have a descriptor set
+	methods map[string]protoreflect.MethodDescriptor
+}
+
+// newMethodResolver loads a descriptor set, or prepares to use reflection if there's none
+func newMethodResolver(descriptorSet string, conn *grpc.ClientConn) (*methodResolver, error) {
+	m := &methodResolver{
+		files:   new(protoregistry.Files),
+		methods: make(map[string]protoreflect.MethodDescriptor),
+	}
+	if descriptorSet == "" {
+		m.conn = conn
+		return m, nil
+	}
+	b, err := os.ReadFile(descriptorSet)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("%s is not a descriptor set, %v", descriptorSet, err)
+	}
+	m.files, err = protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%s is incomplete, was it made with --include_imports? %v", descriptorSet, err)
+	}
+	return m, nil
+}
+
+// find returns the descriptor of a method named like /package.Service/Method
+func (m *methodResolver) find(method string) (protoreflect.MethodDescriptor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if md, ok := m.methods[method]; ok {
+		return md, nil
+	}
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok || service == "" || name == "" {
+		return nil, fmt.Errorf("%q is not a method name like /package.Service/Method", method)
+	}
+	d, err := m.files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil && m.conn != nil {
+		if err = m.reflect(service); err != nil {
+			return nil, err
+		}
+		d, err = m.files.FindDescriptorByName(protoreflect.FullName(service))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("service %s not found, %v", service, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(name))
+	if md == nil {
+		return nil, fmt.Errorf("service %s has no method %s", service, name)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("%s is a streaming method, which isn't supported yet", method)
+	}
+	m.methods[method] = md
+	return md, nil
+}
+
+// reflect asks the server for the file defining symbol, and the files
+// it depends on, and adds them to m.files. Call it with mu held.
+func (m *methodResolver) reflect(symbol string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stream, err := reflectionpb.NewServerReflectionClient(m.conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("server reflection failed, %v", err)
+	}
+	defer stream.CloseSend() // nolint
+
+	protos := make(map[string]*descriptorpb.FileDescriptorProto)
+	ask := func(req *reflectionpb.ServerReflectionRequest) error {
+		if err := stream.Send(req); err != nil {
+			return fmt.Errorf("server reflection failed, %v", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("server reflection failed, %v", err)
+		}
+		if e := resp.GetErrorResponse(); e != nil {
+			return fmt.Errorf("server reflection failed, %s", e.GetErrorMessage())
+		}
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := new(descriptorpb.FileDescriptorProto)
+			if err := proto.Unmarshal(b, fd); err != nil {
+				return fmt.Errorf("server reflection returned a bad descriptor, %v", err)
+			}
+			protos[fd.GetName()] = fd
+		}
+		return nil
+	}
+	known := func(name string) bool {
+		if _, err := m.files.FindFileByPath(name); err == nil {
+			return true
+		}
+		_, err := protoregistry.GlobalFiles.FindFileByPath(name)
+		return err == nil || protos[name] != nil
+	}
+
+	err = ask(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+	})
+	for missing := []string{}; err == nil; missing = missing[:0] {
+		for _, fd := range protos {
+			for _, dep := range fd.GetDependency() {
+				if !known(dep) {
+					missing = append(missing, dep)
+				}
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		for _, dep := range missing {
+			if err = ask(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			}); err != nil {
+				break
+			}
+			if protos[dep] == nil {
+				err = fmt.Errorf("server reflection didn't return %s", dep)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// register them, dependencies first
+	var register func(name string) error
+	register = func(name string) error {
+		if _, err := m.files.FindFileByPath(name); err == nil {
+			return nil
+		}
+		fd := protos[name]
+		if fd == nil {
+			// one of ours, like google/protobuf/empty.proto
+			f, err := protoregistry.GlobalFiles.FindFileByPath(name)
+			if err != nil {
+				return err
+			}
+			return m.files.RegisterFile(f)
+		}
+		for _, dep := range fd.GetDependency() {
+			if err := register(dep); err != nil {
+				return err
+			}
+		}
+		f, err := protodesc.NewFile(fd, m.files)
+		if err != nil {
+			return fmt.Errorf("bad descriptor %s from server reflection, %v", name, err)
+		}
+		return m.files.RegisterFile(f)
+	}
+	for name := range protos {
+		if err = register(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
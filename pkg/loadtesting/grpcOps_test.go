@@ -0,0 +1,101 @@
+package loadtesting
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// TestGRPCCalls replays records against an in-process health service,
+// finding its methods by reflection and then from a descriptor set
+func TestGRPCCalls(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+	go server.Serve(lis) // nolint
+	defer server.Stop()
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto)}}
+	b, _ := proto.Marshal(set)
+	descriptors := filepath.Join(t.TempDir(), "health.pb")
+	if err = os.WriteFile(descriptors, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	savedConf, savedConn, savedMethods := conf, grpcConn, grpcMethods
+	defer func() { conf, grpcConn, grpcMethods = savedConf, savedConn, savedMethods }()
+
+	tests := []struct {
+		path, body, want string
+	}{
+		{"/grpc.health.v1.Health/Check", `{"service": ""}`, "/grpc.health.v1.Health/Check 200 POST"},
+		{"grpc.health.v1.Health/Check", "", "/grpc.health.v1.Health/Check 200 GET"},
+		{"/grpc.health.v1.Health/Check", `{"service": "nope"}`, "/grpc.health.v1.Health/Check 404 POST"},
+		{"/grpc.health.v1.Health/Check", `{"colour": 1}`, "/grpc.health.v1.Health/Check 400 POST"},
+		{"/grpc.health.v1.Health/Watch", `{}`, "/grpc.health.v1.Health/Watch 501 POST"},
+		{"/grpc.health.v1.Nothing/Check", `{}`, "/grpc.health.v1.Nothing/Check 501 POST"},
+	}
+	for _, set := range []string{"", descriptors} {
+		conf = Config{GRPCDescriptors: set}
+		p := GRPCProto{prefix: "grpc://" + lis.Addr().String()}
+		p.Init()
+		for _, test := range tests {
+			out := captureStdout(t, func() {
+				if test.body == "" {
+					p.Get(test.path, "200", options{})
+				} else {
+					p.Post(test.path, "0", "200", test.body, options{})
+				}
+			})
+			if !strings.Contains(out, test.want) {
+				t.Errorf("descriptors %q, %s %s printed %q, want %q", set, test.path, test.body, out, test.want)
+			}
+		}
+	}
+
+	// and a value can be extracted from the response
+	e, _ := parseExtraction("state:json:status")
+	s := newUserSession()
+	captureStdout(t, func() {
+		GRPCProto{}.Post("/grpc.health.v1.Health/Check", "0", "200", `{}`,
+			options{extracts: []extraction{e}, store: s})
+	})
+	if v, _ := s.getVar("state"); v != "SERVING" {
+		t.Errorf("extracted status %q, want SERVING", v)
+	}
+}
+
+// captureStdout returns what f prints
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	done := make(chan []byte)
+	go func() {
+		// read as we go, so a lot of output doesn't fill the pipe
+		out, _ := io.ReadAll(r)
+		done <- out
+	}()
+	f()
+	os.Stdout = saved
+	w.Close() // nolint
+	return string(<-done)
+}
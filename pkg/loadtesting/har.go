@@ -0,0 +1,270 @@
+package loadtesting
+
+// Read and write HTTP Archive (HAR) files, the captures browser dev tools
+// produce. Reading turns each entry into a perf record, so a capture can
+// be replayed. Writing turns perf records, like our results, into a HAR
+// file for inspection in a browser.
+// See http://www.softwareishard.com/blog/har-12-spec/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// harFile is the subset of HAR 1.2 we read and write
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []harNameVal `json:"headers"`
+	QueryString []harNameVal `json:"queryString"`
+	Cookies     []harNameVal `json:"cookies"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []harNameVal `json:"headers"`
+	Cookies     []harNameVal `json:"cookies"`
+	Content     harContent   `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int64        `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+type harNameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// harTimings are in milliseconds, with -1 meaning "does not apply"
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harSkippedHeaders are set by the http client itself, or are
+// HTTP/2 pseudo-headers, so we don't replay them
+var harSkippedHeaders = map[string]bool{
+	"Host":           true,
+	"Content-Length": true,
+	"Connection":     true,
+}
+
+// harReader satisfies recordReader by reading a whole HAR file
+// and returning one entry at a time as a perf record
+type harReader struct {
+	entries []harEntry
+	next    int
+}
+
+// newHARReader reads and decodes a HAR file
+func newHARReader(f io.Reader) (*harReader, error) {
+	var h harFile
+
+	if err := json.NewDecoder(f).Decode(&h); err != nil {
+		return nil, fmt.Errorf("could not decode HAR file, %v", err)
+	}
+	return &harReader{entries: h.Log.Entries}, nil
+}
+
+// Read returns the next entry as a perf record
+func (h *harReader) Read() ([]string, error) {
+	if h.next >= len(h.entries) {
+		return nil, io.EOF
+	}
+	e := h.entries[h.next]
+	h.next++
+	return harToRecord(e)
+}
+
+// FieldPos lets lineOf report the entry number in place of a line
+func (h *harReader) FieldPos(field int) (line, column int) {
+	return h.next, 0
+}
+
+// harToRecord converts a HAR entry to a perf record
+func harToRecord(e harEntry) ([]string, error) {
+	started, err := time.Parse(time.RFC3339Nano, e.StartedDateTime)
+	if err != nil {
+		return nil, fmt.Errorf("entry has a bad startedDateTime, %v", err)
+	}
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, fmt.Errorf("entry has a bad url, %v", err)
+	}
+	size := e.Response.Content.Size
+	if size <= 0 && e.Response.BodySize > 0 {
+		size = e.Response.BodySize
+	}
+	body := ""
+	if e.Request.PostData != nil {
+		body = e.Request.PostData.Text
+	}
+
+	var o options
+	for _, h := range e.Request.Headers {
+		name := http.CanonicalHeaderKey(h.Name)
+		if strings.HasPrefix(h.Name, ":") || harSkippedHeaders[name] {
+			continue
+		}
+		if o.headers == nil {
+			o.headers = make(http.Header)
+		}
+		o.headers.Add(name, h.Value)
+	}
+
+	record := makeRecord(started, harSeconds(e.Timings.Wait), harSeconds(e.Timings.Receive),
+		size, u.RequestURI(), e.Response.Status, e.Request.Method, body)
+	return append(record, o.fields()...), nil
+}
+
+// harSeconds converts HAR milliseconds to perf seconds
+func harSeconds(ms float64) float64 {
+	if ms < 0 {
+		return 0
+	}
+	return ms / 1000
+}
+
+// harWriter satisfies recordWriter by collecting records and
+// writing them as one HAR file when closed
+type harWriter struct {
+	w       io.Writer
+	baseURL string
+	h       harFile
+}
+
+// newHARWriter makes a harWriter. Paths are appended to baseURL.
+func newHARWriter(w io.Writer, baseURL string) *harWriter {
+	return &harWriter{
+		w:       w,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		h: harFile{Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "Play-it-Again-Sam", Version: "1"},
+			Entries: []harEntry{},
+		}},
+	}
+}
+
+// Write converts a perf record to a HAR entry
+func (h *harWriter) Write(r []string) error {
+	if len(r) < 9 {
+		return fmt.Errorf("%d fields instead of at least 9", len(r))
+	}
+	started, err := recordTime(r[dateField], r[timeField])
+	if err != nil {
+		return err
+	}
+	latency, _ := strconv.ParseFloat(r[latencyField], 64)
+	xferTime, _ := strconv.ParseFloat(r[transferTimeField], 64)
+	size, _ := strconv.ParseInt(r[bytesField], 10, 64)
+	rc, _ := strconv.Atoi(r[returnCodeField])
+
+	e := harEntry{
+		StartedDateTime: started.Format(time.RFC3339Nano),
+		Time:            (latency + xferTime) * 1000,
+		Request: harRequest{
+			Method:      r[operatorField],
+			URL:         h.baseURL + "/" + strings.TrimPrefix(r[pathField], "/"),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     []harNameVal{},
+			QueryString: []harNameVal{},
+			Cookies:     []harNameVal{},
+			HeadersSize: -1,
+			BodySize:    0,
+		},
+		Response: harResponse{
+			Status:      rc,
+			StatusText:  http.StatusText(rc),
+			HTTPVersion: "HTTP/1.1",
+			Headers:     []harNameVal{},
+			Cookies:     []harNameVal{},
+			Content:     harContent{Size: size},
+			HeadersSize: -1,
+			BodySize:    size,
+		},
+		Timings: harTimings{
+			Send:    0,
+			Wait:    latency * 1000,
+			Receive: xferTime * 1000,
+		},
+	}
+	if u, err := url.Parse(e.Request.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				e.Request.QueryString = append(e.Request.QueryString, harNameVal{name, v})
+			}
+		}
+	}
+	o := recordOptions(r)
+	for _, name := range sortedHeaderNames(o.headers) {
+		for _, v := range o.headers[name] {
+			e.Request.Headers = append(e.Request.Headers, harNameVal{name, v})
+		}
+	}
+	if r[operatorField] == "POST" || r[operatorField] == "PUT" {
+		if len(r) > bodyField && r[bodyField] != "" {
+			e.Request.PostData = &harPostData{
+				MimeType: o.headers.Get("Content-Type"),
+				Text:     r[bodyField],
+			}
+			e.Request.BodySize = int64(len(r[bodyField]))
+		}
+	}
+	h.h.Log.Entries = append(h.h.Log.Entries, e)
+	return nil
+}
+
+// Close writes the HAR file
+func (h *harWriter) Close() error {
+	enc := json.NewEncoder(h.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(h.h)
+}
@@ -0,0 +1,102 @@
+package loadtesting
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const sampleHAR = `{"log": {"version": "1.2", "creator": {"name": "Firefox", "version": "125"},
+  "entries": [
+    {"startedDateTime": "2024-05-01T10:00:00.120Z", "time": 60,
+     "request": {"method": "GET", "url": "https://shop.example.com/api/items?page=2",
+       "httpVersion": "HTTP/2", "headers": [
+         {"name": ":authority", "value": "shop.example.com"},
+         {"name": "accept", "value": "application/json"},
+         {"name": "Authorization", "value": "Bearer abc.def"}]},
+     "response": {"status": 200, "content": {"size": 5120}, "bodySize": 1024},
+     "timings": {"send": 0, "wait": 50, "receive": 10}},
+    {"startedDateTime": "2024-05-01T10:00:01.000Z", "time": 25,
+     "request": {"method": "POST", "url": "https://shop.example.com/api/cart",
+       "headers": [{"name": "Content-Type", "value": "application/json"}],
+       "postData": {"mimeType": "application/json", "text": "{\"id\": 42}"}},
+     "response": {"status": 201, "content": {"size": 0}, "bodySize": -1},
+     "timings": {"send": 1, "wait": 20, "receive": -1}}
+  ]}}`
+
+// TestHARReader checks that HAR entries become replayable perf records
+func TestHARReader(t *testing.T) {
+	r, err := newHARReader(strings.NewReader(sampleHAR))
+	if err != nil {
+		t.Fatalf("newHARReader failed, %v", err)
+	}
+	get, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read failed, %v", err)
+	}
+	if err = checkRecord(get); err != nil {
+		t.Errorf("GET record %q is malformed, %v", get, err)
+	}
+	expected := []string{"2024-05-01", "10:00:00.120", "0.05", "0.01", "0", "5120",
+		"/api/items?page=2", "200", "GET", ""}
+	for i, field := range expected {
+		if get[i] != field {
+			t.Errorf("field %d of GET = %q, expected %q", i, get[i], field)
+		}
+	}
+	o := recordOptions(get)
+	if o.headers.Get("Accept") != "application/json" || o.headers.Get("Authorization") != "Bearer abc.def" {
+		t.Errorf("GET headers = %v, expected accept and authorization", o.headers)
+	}
+	if _, ok := o.headers[":authority"]; ok {
+		t.Errorf("GET headers kept the :authority pseudo-header")
+	}
+
+	post, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read failed, %v", err)
+	}
+	if post[operatorField] != "POST" || post[bodyField] != `{"id": 42}` || post[transferTimeField] != "0" {
+		t.Errorf("POST record = %q, expected a body and zero transfer time", post)
+	}
+	if _, err = r.Read(); err != io.EOF {
+		t.Errorf("expected EOF after two entries, got %v", err)
+	}
+}
+
+// TestHARRoundTrip writes perf records as HAR, and reads them back
+func TestHARRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	r, _ := newHARReader(strings.NewReader(sampleHAR))
+	w := newHARWriter(&buf, "https://shop.example.com/")
+	var originals [][]string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		originals = append(originals, record)
+		if err = w.Write(record); err != nil {
+			t.Fatalf("Write(%q) failed, %v", record, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed, %v", err)
+	}
+
+	r, err := newHARReader(&buf)
+	if err != nil {
+		t.Fatalf("could not reread our own HAR output, %v", err)
+	}
+	for _, original := range originals {
+		record, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read failed, %v", err)
+		}
+		if strings.Join(record, "|") != strings.Join(original, "|") {
+			t.Errorf("round trip changed\n%q\nto\n%q", original, record)
+		}
+	}
+}
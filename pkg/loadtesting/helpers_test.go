@@ -0,0 +1,18 @@
+package loadtesting
+
+// Helpers for tests of more than one part of the package
+
+import (
+	"os"
+	"testing"
+)
+
+// mustOpen opens a file, or fails the test
+func mustOpen(t *testing.T, name string) *os.File {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() }) // nolint
+	return f
+}
@@ -0,0 +1,210 @@
+package loadtesting
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// TestHTTPVersions checks which protocol each version setting gets
+// from an HTTP/2-capable TLS server
+func TestHTTPVersions(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	ca := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	savedConf := conf
+	defer func() { conf = savedConf }()
+
+	tests := []struct {
+		version, want string
+	}{
+		{"1.1", "HTTP/1.1"},
+		{"auto", "HTTP/2.0"},
+		{"2", "HTTP/2.0"},
+	}
+	for _, test := range tests {
+		conf = Config{TLSCA: ca, HTTPVersion: test.version}
+		client, err := newHTTPClient(conf)
+		if err != nil {
+			t.Fatalf("newHTTPClient(%s) failed, %v", test.version, err)
+		}
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req, timing := withTiming(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s: Get failed, %v", test.version, err)
+			continue
+		}
+		resp.Body.Close() // nolint
+		timing.setProto(resp.Proto)
+		if resp.Proto != test.want {
+			t.Errorf("%s: got %s, want %s", test.version, resp.Proto, test.want)
+		}
+		annotation := timing.annotation()
+		if !strings.Contains(annotation, " tls=") ||
+			strings.Contains(annotation, "proto=") != (test.version != "1.1") {
+			t.Errorf("%s: annotation %q, want a tls time and, unless 1.1, the protocol", test.version, annotation)
+		}
+	}
+
+	// a server that only speaks HTTP/1.1
+	old := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer old.Close()
+	client, _ := newHTTPClient(Config{TLSInsecure: true, HTTPVersion: "2"})
+	if resp, err := client.Get(old.URL); err == nil {
+		resp.Body.Close() // nolint
+		t.Errorf("HTTP/2 Get from an HTTP/1.1-only server succeeded")
+	}
+	if _, err := newHTTPClient(Config{HTTPVersion: "3"}); err == nil {
+		t.Errorf("newHTTPClient accepted http version 3")
+	}
+	// options HTTP/2 can't honour
+	for _, cfg := range []Config{{HTTPVersion: "2", NoKeepAlive: true}, {HTTPVersion: "h2c", MaxConnsPerHost: 4}} {
+		if _, err := newHTTPClient(cfg); err == nil {
+			t.Errorf("newHTTPClient accepted %+v", cfg)
+		}
+	}
+}
+
+// TestH2CStreams checks that h2c works, and that -h2-streams limits
+// how many requests share a connection
+func TestH2CStreams(t *testing.T) {
+	var mu sync.Mutex
+	var connections int
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // so the requests overlap
+		io.WriteString(w, r.Proto)         // nolint
+	})
+	server := httptest.NewUnstartedServer(h2c.NewHandler(handler, &http2.Server{}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		streams, want int
+	}{
+		{0, 1},
+		{2, 2},
+		{1, 4},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		connections = 0
+		mu.Unlock()
+		client, err := newHTTPClient(Config{HTTPVersion: "h2c", H2Streams: test.streams})
+		if err != nil {
+			t.Fatalf("newHTTPClient failed, %v", err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					t.Errorf("h2c Get failed, %v", err)
+					return
+				}
+				defer resp.Body.Close() // nolint
+				body, _ := io.ReadAll(resp.Body)
+				if resp.Proto != "HTTP/2.0" || string(body) != "HTTP/2.0" {
+					t.Errorf("h2c Get used %s, and the server saw %s", resp.Proto, body)
+				}
+			}()
+		}
+		wg.Wait()
+		mu.Lock()
+		if connections != test.want {
+			t.Errorf("%d streams per connection, server saw %d connections, want %d",
+				test.streams, connections, test.want)
+		}
+		mu.Unlock()
+	}
+}
+
+// TestStreamPoolDials checks that a burst shares the connections being
+// dialed, and that a slow dial to one server doesn't hold up another
+func TestStreamPoolDials(t *testing.T) {
+	handler := h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // so the requests overlap
+	}), &http2.Server{})
+	slow := httptest.NewServer(handler)
+	defer slow.Close()
+	fast := httptest.NewServer(handler)
+	defer fast.Close()
+
+	client, err := newHTTPClient(Config{HTTPVersion: "h2c", H2Streams: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := client.Transport.(*http2.Transport).ConnPool.(*streamPool)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	dials := make(map[string]int)
+	dial := pool.dial
+	pool.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+		mu.Lock()
+		dials[addr]++
+		mu.Unlock()
+		if "http://"+addr == slow.URL {
+			<-release
+		}
+		return dial(ctx, addr)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(slow.URL)
+			if err != nil {
+				t.Errorf("Get from the slow server failed, %v", err)
+				return
+			}
+			resp.Body.Close() // nolint
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // until they're waiting on the dial
+	done := make(chan error)
+	go func() {
+		resp, err := client.Get(fast.URL)
+		if err == nil {
+			resp.Body.Close() // nolint
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Get from the fast server failed, %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("a slow dial held up a request to another server")
+	}
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if n := dials[strings.TrimPrefix(slow.URL, "http://")]; n != 2 {
+		t.Errorf("8 requests at 4 streams a connection made %d dials, want 2", n)
+	}
+}
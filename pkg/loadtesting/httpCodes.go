@@ -15,7 +15,7 @@ type codeTable struct {
 func codeDescr(errorValue int) (string, bool) {
 	val, present := codeMap[errorValue]
 	if !present {
-		return string(errorValue) + " not defined", false
+		return strconv.Itoa(errorValue) + " not defined", false
 	}
 	return strconv.Itoa(errorValue) + " " + val.descr, val.create
 }
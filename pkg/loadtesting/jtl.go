@@ -0,0 +1,297 @@
+package loadtesting
+
+// Read JMeter results (JTL) files, in either their XML or CSV variant,
+// as perf records, so recorded JMeter traffic can be replayed.
+// Replaces scripts/jmeter2perf, which only understood one XML layout.
+//
+// XML looks like
+//	<httpSample t="42" lt="30" ts="1144094509435" lb="Home" rc="200" by="1234">
+//	  <method>GET</method><java.net.URL>http://host/index.html</java.net.URL>
+//	</httpSample>
+// or, in older versions,
+//	<sampleResult timeStamp="1144094509435" time="42" label="http://host/x" responseCode="200"/>
+// CSV looks like
+//	timeStamp,elapsed,label,responseCode,responseMessage,threadName,...,bytes,...,URL,Latency
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jtlSample is one request, in either variant
+type jtlSample struct {
+	timeStamp time.Time
+	elapsed   int64 // milliseconds
+	latency   int64 // milliseconds, zero if not recorded
+	label     string
+	url       string
+	method    string
+	code      string
+	bytes     int64
+	body      string
+}
+
+// jtlDefaultColumns are the CSV columns JMeter writes if there is no header line
+var jtlDefaultColumns = []string{"timeStamp", "elapsed", "label", "responseCode",
+	"responseMessage", "threadName", "dataType", "success", "failureMessage",
+	"bytes", "sentBytes", "grpThreads", "allThreads", "URL", "Latency", "IdleTime", "Connect"}
+
+// jtlReader satisfies recordReader by reading a whole JTL file,
+// and returning its samples in time order
+type jtlReader struct {
+	samples []jtlSample
+	next    int
+}
+
+// newJTLReader reads a JTL file, deciding from its first character
+// if it is XML or CSV
+func newJTLReader(f io.Reader) (*jtlReader, error) {
+	var samples []jtlSample
+
+	br := bufio.NewReader(f)
+	c, _, err := br.ReadRune()
+	for err == nil && strings.ContainsRune(" \t\r\n\ufeff", c) {
+		c, _, err = br.ReadRune()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read JTL file, %v", err)
+	}
+	_ = br.UnreadRune()
+	if c == '<' {
+		samples, err = readJTLXML(br)
+	} else {
+		samples, err = readJTLCSV(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// threads finish out of order, so put them back in order
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].timeStamp.Before(samples[j].timeStamp)
+	})
+	return &jtlReader{samples: samples}, nil
+}
+
+// Read returns the next sample as a perf record
+func (j *jtlReader) Read() ([]string, error) {
+	if j.next >= len(j.samples) {
+		return nil, io.EOF
+	}
+	s := j.samples[j.next]
+	j.next++
+	return s.record(), nil
+}
+
+// FieldPos lets lineOf report the sample number in place of a line
+func (j *jtlReader) FieldPos(field int) (line, column int) {
+	return j.next, 0
+}
+
+// record converts a sample to a perf record. The latency is the time
+// to the first byte if JMeter recorded it, and the elapsed time if not
+func (s jtlSample) record() []string {
+	latency := s.latency
+	if latency <= 0 || latency > s.elapsed {
+		latency = s.elapsed
+	}
+	rc, err := strconv.Atoi(s.code)
+	if err != nil {
+		// eg, "Non HTTP response code: java.net.SocketException",
+		// so use nginx's code for "no response"
+		rc = 444
+	}
+	method := s.method
+	if method == "" {
+		method = "GET"
+	}
+	return makeRecord(s.timeStamp, float64(latency)/1000, float64(s.elapsed-latency)/1000,
+		s.bytes, s.path(), rc, method, s.body)
+}
+
+// path is from the url, if there is one, otherwise from the label
+func (s jtlSample) path() string {
+	for _, candidate := range []string{s.url, s.label} {
+		u, err := url.Parse(candidate)
+		if err == nil && u.Host != "" {
+			return u.RequestURI()
+		}
+	}
+	return s.label
+}
+
+// jtlXMLSample is an httpSample, sample or sampleResult element,
+// with the short attribute names of current JMeter or the long ones of
+// old versions
+type jtlXMLSample struct {
+	XMLName      xml.Name
+	T            string         `xml:"t,attr"`
+	Time         string         `xml:"time,attr"`
+	Lt           string         `xml:"lt,attr"`
+	Latency      string         `xml:"latency,attr"`
+	Ts           string         `xml:"ts,attr"`
+	TimeStamp    string         `xml:"timeStamp,attr"`
+	Lb           string         `xml:"lb,attr"`
+	Label        string         `xml:"label,attr"`
+	Rc           string         `xml:"rc,attr"`
+	ResponseCode string         `xml:"responseCode,attr"`
+	By           string         `xml:"by,attr"`
+	Bytes        string         `xml:"bytes,attr"`
+	Method       string         `xml:"method"`
+	URL          string         `xml:"java.net.URL"`
+	QueryString  string         `xml:"queryString"`
+	Children     []jtlXMLSample `xml:",any"`
+}
+
+// readJTLXML reads the XML variant. Where a sample contains sub-samples,
+// as transaction controllers and redirects do, the sub-samples are used
+func readJTLXML(f io.Reader) ([]jtlSample, error) {
+	var results struct {
+		Samples []jtlXMLSample `xml:",any"`
+	}
+	var samples []jtlSample
+
+	if err := xml.NewDecoder(f).Decode(&results); err != nil {
+		return nil, fmt.Errorf("could not decode JTL XML, %v", err)
+	}
+	var walk func(x []jtlXMLSample) error
+	walk = func(x []jtlXMLSample) error {
+		for _, e := range x {
+			if !jtlIsSample(e.XMLName.Local) {
+				continue
+			}
+			if hasSamples(e.Children) {
+				if err := walk(e.Children); err != nil {
+					return err
+				}
+				continue
+			}
+			s, err := e.sample()
+			if err != nil {
+				return err
+			}
+			samples = append(samples, s)
+		}
+		return nil
+	}
+	return samples, walk(results.Samples)
+}
+
+// jtlIsSample is true for the elements that describe a request
+func jtlIsSample(name string) bool {
+	return name == "httpSample" || name == "sample" || name == "sampleResult"
+}
+
+// hasSamples is true if any of the elements are samples
+func hasSamples(x []jtlXMLSample) bool {
+	for _, e := range x {
+		if jtlIsSample(e.XMLName.Local) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample converts an XML element to a jtlSample
+func (e jtlXMLSample) sample() (jtlSample, error) {
+	var s jtlSample
+	var err error
+
+	s.timeStamp, err = jtlTime(firstOf(e.Ts, e.TimeStamp))
+	if err != nil {
+		return s, err
+	}
+	s.elapsed, _ = strconv.ParseInt(firstOf(e.T, e.Time), 10, 64)
+	s.latency, _ = strconv.ParseInt(firstOf(e.Lt, e.Latency), 10, 64)
+	s.bytes, _ = strconv.ParseInt(firstOf(e.By, e.Bytes), 10, 64)
+	s.label = firstOf(e.Lb, e.Label)
+	s.code = firstOf(e.Rc, e.ResponseCode)
+	s.method = strings.TrimSpace(e.Method)
+	s.url = strings.TrimSpace(e.URL)
+	if s.method == "POST" || s.method == "PUT" {
+		s.body = e.QueryString
+	}
+	return s, nil
+}
+
+// readJTLCSV reads the CSV variant, with or without a header line
+func readJTLCSV(f io.Reader) ([]jtlSample, error) {
+	var samples []jtlSample
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	columns := map[string]int{}
+	for i, name := range jtlDefaultColumns {
+		columns[name] = i
+	}
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read JTL CSV, %v", err)
+		}
+		if line == 1 && len(record) > 0 && record[0] == "timeStamp" {
+			columns = map[string]int{}
+			for i, name := range record {
+				columns[name] = i
+			}
+			continue
+		}
+		get := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+
+		var s jtlSample
+		s.timeStamp, err = jtlTime(get("timeStamp"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		s.elapsed, _ = strconv.ParseInt(get("elapsed"), 10, 64)
+		s.latency, _ = strconv.ParseInt(get("Latency"), 10, 64)
+		s.bytes, _ = strconv.ParseInt(get("bytes"), 10, 64)
+		s.label = get("label")
+		s.code = get("responseCode")
+		s.url = get("URL")
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
+
+// jtlTime converts a JMeter timestamp, normally milliseconds since
+// the epoch, to a time
+func jtlTime(ts string) (time.Time, error) {
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	for _, layout := range []string{"2006/01/02 15:04:05", "2006-01-02 15:04:05"} {
+		t, err := time.Parse(layout, ts)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized JMeter timestamp %q", ts)
+}
+
+// firstOf returns the first non-empty string
+func firstOf(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
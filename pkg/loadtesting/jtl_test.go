@@ -0,0 +1,109 @@
+package loadtesting
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const sampleJTLXML = `<?xml version="1.0" encoding="UTF-8"?>
+<testResults version="1.2">
+<httpSample t="42" lt="30" ts="1714557601500" s="true" lb="Cart" rc="201" rm="Created" tn="Users 1-2" by="17">
+  <method class="java.lang.String">POST</method>
+  <java.net.URL>http://shop.example.com/api/cart</java.net.URL>
+  <queryString class="java.lang.String">{"id": 42}</queryString>
+</httpSample>
+<sample t="100" ts="1714557600000" lb="Home transaction" rc="200">
+  <httpSample t="60" lt="20" ts="1714557600000" lb="Home" rc="200" by="5120">
+    <method>GET</method>
+    <java.net.URL>http://shop.example.com/index.html?lang=en</java.net.URL>
+  </httpSample>
+</sample>
+<sampleResult timeStamp="1714557602000" time="9" label="http://shop.example.com/old" responseCode="Non HTTP response code: java.net.SocketException"/>
+</testResults>
+`
+
+const sampleJTLCSV = `timeStamp,elapsed,label,responseCode,responseMessage,threadName,dataType,success,failureMessage,bytes,sentBytes,grpThreads,allThreads,URL,Latency,IdleTime,Connect
+1714557601000,50,Search,200,OK,Users 1-1,text,true,,2048,120,1,1,http://shop.example.com/search?q=hat,45,0,3
+1714557600000,80,"Home, again",404,Not Found,Users 1-2,text,false,,10,120,1,1,null,0,0,3
+`
+
+// TestJTLReader checks both XML and CSV variants become perf records, in time order
+func TestJTLReader(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		expected []string // path, latency, xfertime, rc, op
+	}{
+		{"xml", sampleJTLXML, []string{
+			"/index.html?lang=en 0.02 0.04 200 GET",
+			"/api/cart 0.03 0.012 201 POST",
+			"/old 0.009 0 444 GET",
+		}},
+		{"csv", sampleJTLCSV, []string{
+			"Home, again 0.08 0 404 GET",
+			"/search?q=hat 0.045 0.005 200 GET",
+		}},
+	}
+	for _, test := range tests {
+		r, err := newJTLReader(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatalf("%s: newJTLReader failed, %v", test.name, err)
+		}
+		for _, expected := range test.expected {
+			record, err := r.Read()
+			if err != nil {
+				t.Fatalf("%s: Read failed, %v", test.name, err)
+			}
+			got := strings.Join([]string{record[pathField], record[latencyField],
+				record[transferTimeField], record[returnCodeField], record[operatorField]}, " ")
+			if got != expected {
+				t.Errorf("%s: got %q, expected %q", test.name, got, expected)
+			}
+			if err = checkRecord(record); err != nil {
+				t.Errorf("%s: record %q is malformed, %v", test.name, record, err)
+			}
+		}
+		if _, err = r.Read(); err != io.EOF {
+			t.Errorf("%s: expected EOF, got %v", test.name, err)
+		}
+	}
+}
+
+// TestSecondsWriter checks the per-second and per-label aggregates
+func TestSecondsWriter(t *testing.T) {
+	records := [][]string{
+		{"2024-05-01", "10:00:00.100", "0.1", "0", "0", "100", "/a", "200", "GET"},
+		{"2024-05-01", "10:00:00.900", "0.3", "0", "0", "300", "/b", "404", "GET"},
+		{"2024-05-01", "10:00:01.000", "0.5", "0", "0", "10", "/a", "200", "GET"},
+		{"2024-05-01", "10:00:01.500", "0.5", "0", "0", "10", "/a", "304", "GET"},
+	}
+	var tests = []struct {
+		byPath   bool
+		expected string
+	}{
+		{false, "#date time latency xfertime sleeptime bytes requests errors\n" +
+			"2024-05-01 10:00:00 0.200000 0.000000 0.000000 200 2 1\n" +
+			"2024-05-01 10:00:01 0.500000 0.000000 0.000000 10 2 0\n"},
+		{true, "#date time latency xfertime sleeptime bytes requests errors path\n" +
+			"2024-05-01 10:00:00 0.100000 0.000000 0.000000 100 1 0 \"/a\"\n" +
+			"2024-05-01 10:00:00 0.300000 0.000000 0.000000 300 1 1 \"/b\"\n" +
+			"2024-05-01 10:00:01 0.500000 0.000000 0.000000 10 2 0 \"/a\"\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w := newSecondsWriter(&buf, test.byPath)
+		for _, r := range records {
+			if err := w.Write(r); err != nil {
+				t.Fatalf("Write(%q) failed, %v", r, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed, %v", err)
+		}
+		if buf.String() != test.expected {
+			t.Errorf("byPath=%v got\n%s\nexpected\n%s", test.byPath, buf.String(), test.expected)
+		}
+	}
+}
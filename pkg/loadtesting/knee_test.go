@@ -0,0 +1,148 @@
+package loadtesting
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPredictedResponse checks the queueing formulae against textbook cases
+func TestPredictedResponse(t *testing.T) {
+	const s = 20 * time.Millisecond
+	tests := []struct {
+		model   string
+		servers int
+		lambda  float64
+		want    time.Duration
+	}{
+		{"M/M/c", 1, 0, s},
+		{"M/M/c", 1, 25, 2 * s},                     // rho = 0.5, so s / (1 - rho)
+		{"M/D/c", 1, 25, s + s/2},                   // Pollaczek-Khinchine for M/D/1
+		{"M/M/c", 2, 50, s + s/3},                   // a = 1, and C(2, 1) = 1/3
+		{"M/M/c", 4, 200, time.Duration(1<<63 - 1)}, // saturated
+	}
+	for _, test := range tests {
+		got := PredictedResponse(test.model, test.servers, s, test.lambda)
+		if diff := got - test.want; diff < -time.Microsecond || diff > time.Microsecond {
+			t.Errorf("%s with c=%d at %g/s predicted %s, want %s", test.model, test.servers,
+				test.lambda, got, test.want)
+		}
+	}
+}
+
+// TestMeasuredKnee drives an M/M/c simulator with a progressive load,
+// and checks that the knee in the measured response times is where
+// queueing theory puts it. Our arrivals are more regular than random
+// ones, so queues build later than in M/M/c, but they must build by the
+// time the offered load passes capacity. And a worker's first request
+// comes a second or two after it starts, so each step's results are
+// mostly at the previous step's rate.
+func TestMeasuredKnee(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes about 12 seconds")
+	}
+	const servers, service = 4, 20 * time.Millisecond // capacity 200/s
+	const start, step, target = 25, 25, 250
+	s, err := newSimulator(Config{SimModel: "M/M/c", SimServers: servers, SimService: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	name := filepath.Join(t.TempDir(), "load.csv")
+	if err = os.WriteFile(name, []byte("2024-05-01 10:00:00 0 0 0 0 items 200 GET\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close() // nolint
+	savedConf, savedOp, savedCleanup := conf, op, cleanupTime
+	defer func() { conf, op, cleanupTime = savedConf, savedOp, savedCleanup }()
+	cleanupTime = 2 * time.Second
+
+	out := captureStdout(t, func() {
+		runLoadTest(f, name, 0, math.MaxInt, target, step, start, server.URL,
+			Config{Protocol: RESTProtocol, R: true, Rewind: true, StepDuration: 1, Cache: true})
+	})
+
+	// the mean latency at each offered rate
+	sums := make(map[int]float64)
+	counts := make(map[int]int)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		latency, err1 := strconv.ParseFloat(fields[latencyField], 64)
+		rate, err2 := strconv.Atoi(fields[bodyField])
+		if err1 != nil || err2 != nil {
+			t.Fatalf("unexpected output line %q", line)
+		}
+		sums[rate] += latency
+		counts[rate]++
+	}
+	var rates []int
+	for rate := range counts {
+		rates = append(rates, rate)
+	}
+	sort.Ints(rates)
+
+	// the knee is where the response time reaches half again the service
+	// time, and stays there, so a step slowed by a busy test machine
+	// isn't taken for it
+	measured := 0
+	for _, rate := range rates {
+		mean := sums[rate] / float64(counts[rate])
+		t.Logf("%d requests/second, mean latency %.4fs over %d requests", rate, mean, counts[rate])
+		switch {
+		case mean <= 1.5*service.Seconds():
+			measured = 0
+		case measured == 0:
+			measured = rate
+		}
+	}
+	theory := 0.0
+	for lambda := 0.0; lambda < float64(target); lambda++ {
+		if PredictedResponse("M/M/c", servers, service, lambda) > 3*service/2 {
+			theory = lambda
+			break
+		}
+	}
+	capacity := float64(servers) / service.Seconds()
+	t.Logf("measured knee %d requests/second, M/M/c %g, capacity %g", measured, theory, capacity)
+	if measured == 0 || float64(measured) < theory || float64(measured) > capacity+2*step {
+		t.Errorf("measured a knee at %d requests/second, want between %g and %g",
+			measured, theory, capacity+2*step)
+	}
+
+	// and the simulator agrees it was nearly saturated
+	resp, err := http.Get(server.URL + statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close() // nolint
+	var rep simReport
+	if err = json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("stats %+v", rep)
+	if rep.Capacity != capacity || rep.Utilisation <= 0.4 || rep.Utilisation > 1 || rep.Completed == 0 {
+		t.Errorf("stats %+v, want a capacity of %g/s and a high utilisation", rep, capacity)
+	}
+	// the busy time is the service times, plus a little for sleeping
+	if mean := rep.BusySeconds / float64(rep.Completed); mean < 0.8*service.Seconds() ||
+		mean > 1.2*service.Seconds() {
+		t.Errorf("mean service time %gs, want about %s", mean, service)
+	}
+}
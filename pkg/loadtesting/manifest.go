@@ -0,0 +1,169 @@
+package loadtesting
+
+// manifest lists what mkLoadTestFiles has created, so that a run that
+// stopped part-way can resume, and so the data can be checked later.
+// It looks like
+//
+//	#path size sha256 content=random
+//	/zaphod-beeblebrox.jpg 7623 5e8f2a...
+//
+// Lines are written as each file is created, so after a crash all but
+// perhaps the last are complete. Anything unreadable is ignored, and
+// so gets created again. The --content kind is in the header, as files
+// of another kind would have the same sizes but different checksums, so
+// resuming with another kind is refused.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// manifest is the file, and what a run being resumed had created
+type manifest struct {
+	sync.Mutex
+	f       *os.File         // nil if we're not keeping one
+	content string           // the kind of content of the files
+	created map[string]int64 // path to size
+}
+
+// openManifest starts a manifest of files of a kind of content, or
+// continues one if resuming. With no name, there's no manifest and
+// nothing to resume.
+func openManifest(name, content string, resume bool) (*manifest, error) {
+	if content == "" {
+		content = contentKinds[0]
+	}
+	m := &manifest{content: content, created: make(map[string]int64)}
+	if name == "" {
+		if resume {
+			return nil, fmt.Errorf("there's no manifest to resume from")
+		}
+		return m, nil
+	}
+	if !resume {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		m.f = f
+		if err = m.writeHeader(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	m.f = f
+	kind, err := m.read(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest %s, %v", name, err)
+	}
+	if kind != "" && kind != content {
+		f.Close() // nolint
+		return nil, fmt.Errorf("manifest %s lists %s content, not %s, so can't be resumed with it",
+			name, kind, content)
+	}
+	// a crash can leave the last line unfinished, which mustn't run
+	// into the first one we append
+	if err = endLine(f); err != nil {
+		return nil, fmt.Errorf("could not finish manifest %s, %v", name, err)
+	}
+	if kind == "" && len(m.created) == 0 {
+		// a new one, so it needs a header
+		if err = m.writeHeader(); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("resuming, the manifest lists %d files already created\n", len(m.created))
+	return m, nil
+}
+
+// writeHeader writes the header line, with the kind of content
+func (m *manifest) writeHeader() error {
+	_, err := fmt.Fprintf(m.f, "#path size sha256 content=%s\n", m.content)
+	return err
+}
+
+// endLine adds a newline to a file that doesn't end in one
+func endLine(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err = f.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = io.WriteString(f, "\n")
+	}
+	return err
+}
+
+// read loads the paths and sizes of a manifest, and returns the kind
+// of content its header says they have, if it says
+func (m *manifest) read(r io.Reader) (string, error) {
+	var kind string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			for _, field := range strings.Fields(line) {
+				if k, found := strings.CutPrefix(field, "content="); found {
+					kind = k
+				}
+			}
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			log.Printf("ignoring manifest line %q\n", line)
+			continue
+		}
+		size, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			log.Printf("ignoring manifest line %q\n", line)
+			continue
+		}
+		m.created[f[0]] = size
+	}
+	return kind, scanner.Err()
+}
+
+// done is true if the manifest says w has already been created
+func (m *manifest) done(w wantedFile) bool {
+	size, ok := m.created[w.path]
+	return ok && size == w.size
+}
+
+// add records that w has been created
+func (m *manifest) add(w wantedFile) error {
+	if m.f == nil {
+		return nil
+	}
+	sum := contentChecksum(w.path, w.size) // without the lock, as it may take a while
+
+	m.Lock()
+	defer m.Unlock()
+	// one write per line, so lines from different workers don't mix
+	_, err := fmt.Fprintf(m.f, "%s %d %s\n", w.path, w.size, sum)
+	return err
+}
+
+// Close closes the manifest file, if any
+func (m *manifest) Close() error {
+	if m.f == nil {
+		return nil
+	}
+	return m.f.Close()
+}
@@ -4,38 +4,78 @@ package loadtesting
 // that GETs, not PUTs, POSTs or DELETEs. PUTs are easy, as are DELETEs,
 // but POSTs are ambiguous.
 // input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 200 GET"
+// Files are created in a local filesystem, or as objects with the same
+// PUTs runLoadTest uses for REST and S3, by a pool of workers. Each is
+// created once, at the largest size any record asks for, and a manifest
+// of what's been created lets an interrupted run pick up where it was.
 
 import (
-	"encoding/csv"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 )
 
+// wantedFile is a file or object to create
+type wantedFile struct {
+	path string
+	size int64
+}
+
 // MkLoadTestFiles interprets the time period and decides what to create.
 func MkLoadTestFiles(f *os.File, filename, baseURL string, startFrom, runFor int, cfg Config) {
+	conf = cfg
 	if conf.Debug {
 		log.Printf("in MkLoadTestFiles(f *os.File, filename=%s, baseURL=%s, startFrom=%d, runFor=%d)",
 			filename, baseURL, startFrom, runFor)
 	}
+	if err := checkContent(conf.Content); err != nil {
+		log.Fatalf("Fatal error in --content: %s, halting\n", err)
+	}
+	m, err := openManifest(conf.MkManifest, conf.Content, conf.MkResume)
+	if err != nil {
+		log.Fatalf("Fatal error opening manifest: %s, halting\n", err)
+	}
+	defer m.Close() // nolint
 
-	conf = cfg
-	//doPrepWork(baseURL)    use op.Init()
-	defer os.Remove(junkDataFile) // nolint
-
-	r := csv.NewReader(f)
-	r.Comma = ' '
-	r.Comment = '#'
-	r.FieldsPerRecord = -1 // ignore differences
+	mustSetDataOperation(baseURL)
 
+	r := newPerfReader(f)
 	skipForward(startFrom, r, filename)
-	makeFiles(runFor, r, filename, baseURL, conf.Zero)
+	files := selectFiles(runFor, r, filename, false)
+	makeFiles(files, filename, baseURL, m)
+}
+
+// mustSetDataOperation sets op to the protocol that creates and deletes
+// data, as runLoadTest does
+func mustSetDataOperation(baseURL string) {
+	switch conf.Protocol {
+	case FilesystemProtocol:
+		// we create directories as we go, so there's nothing to check
+		op = FilesystemProto{root: baseURL}
+	case RESTProtocol, S3Protocol:
+		var err error
+		httpClient = mustMakeHTTPClient(conf)
+		auth, err = newAuthenticator(conf)
+		if err != nil {
+			log.Fatalf("Fatal error setting up %s authentication: %s, halting\n", conf.Auth, err)
+		}
+		if conf.Protocol == S3Protocol {
+			op = S3Proto{prefix: baseURL}
+		} else {
+			op = RestProto{prefix: baseURL}
+		}
+		op.Init()
+	default:
+		log.Fatalf("Unimplemented protocol %d, halting\n", conf.Protocol)
+	}
 }
 
 // skipForward skips over files we don't want to create
-func skipForward(startFrom int, r *csv.Reader, filename string) {
+func skipForward(startFrom int, r recordReader, filename string) {
 	//skip forward if startFrom is non-zero
 	for i := 0; i < startFrom; i++ {
 		record, err := r.Read()
@@ -49,8 +89,28 @@ func skipForward(startFrom int, r *csv.Reader, filename string) {
 	}
 }
 
-// makeFiles creates a quantity of files
-func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string, aero bool) {
+// selectFiles reads the records and decides what to create, once per
+// path, at the largest size asked for. With puts, it includes what the
+// PUTs will create, for deleting afterwards.
+func selectFiles(runFor int, r recordReader, filename string, puts bool) []wantedFile {
+	var files []wantedFile
+	seen := make(map[string]int) // index in files
+
+	want := func(path, size string) {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			log.Fatalf("can't get size from %q", size)
+		}
+		if i, ok := seen[path]; ok {
+			if n > files[i].size {
+				files[i].size = n
+			}
+			return
+		}
+		seen[path] = len(files)
+		files = append(files, wantedFile{path: path, size: n})
+	}
+
 	for i := 0; i < runFor; i++ {
 		record, err := r.Read()
 		if err == io.EOF {
@@ -59,7 +119,9 @@ func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string, aero
 		if err != nil {
 			log.Fatalf("Fatal error mid-way in %s: %s, halting\n", filename, err)
 		}
-		log.Printf("read %s\n", record)
+		if conf.Debug {
+			log.Printf("read %s\n", record)
+		}
 
 		// record-type logic:
 		if record[pathField] == "/" {
@@ -80,12 +142,16 @@ func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string, aero
 		operatorValue := record[operatorField]
 		switch operatorValue {
 		case "PUT", "POST":
+			if operatorValue == "PUT" && puts {
+				want(path, bytes)
+				continue
+			}
 			// Don't do files that will be created in the test
 			log.Printf("ignored %s operation on %s\n", operatorValue, path)
 			continue
 		case "DELETE", "DELE":
 			// Right now, create a 0-byte file to provide something to delete.
-			mkFile(baseURL, filename, path, "0")
+			want(path, "0")
 			continue
 		case "GET", "":
 			// Treat get as the default
@@ -95,40 +161,100 @@ func makeFiles(runFor int, r *csv.Reader, filename string, baseURL string, aero
 			}
 			shortDescr, create := codeDescr(rc)
 			if create {
-				log.Printf("Got %s, created file %q of %s bytes\n", shortDescr, path, bytes)
-				mkFile(baseURL, filename, path, bytes)
+				log.Printf("Got %s, create file %q of %s bytes\n", shortDescr, path, bytes)
+				want(path, bytes)
 			} else {
 				log.Printf("Got %s, don't create file %q\n", shortDescr, path)
 			}
 		}
 	}
+	return files
+}
+
+// makeFiles creates the files, skipping those the manifest says are done
+func makeFiles(files []wantedFile, filename, baseURL string, m *manifest) {
+	var todo []wantedFile
+
+	for _, w := range files {
+		if !m.done(w) {
+			todo = append(todo, w)
+		}
+	}
+	if skipped := len(files) - len(todo); skipped > 0 {
+		log.Printf("skipped %d files the manifest says were created\n", skipped)
+	}
+	inParallel(todo, conf.MkWorkers, conf.MkRate, func(w wantedFile) {
+		if err := mkFile(baseURL, filename, w.path, w.size); err != nil {
+			// so a resume tries it again
+			log.Printf("%s, not adding it to the manifest\n", err)
+			return
+		}
+		if err := m.add(w); err != nil {
+			log.Fatalf("Fatal error writing manifest: %s, halting\n", err)
+		}
+	})
+}
+
+// inParallel does do to each of files with a pool of workers, starting
+// up to rate a second, or as fast as they can if rate is 0
+func inParallel(files []wantedFile, workers, rate int, do func(w wantedFile)) {
+	var wg sync.WaitGroup
+	var tick <-chan time.Time
+
+	if workers <= 0 {
+		workers = 1
+	}
+	if rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	work := make(chan wantedFile, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range work {
+				do(w)
+			}
+		}()
+	}
+	for _, w := range files {
+		if tick != nil {
+			<-tick
+		}
+		work <- w
+	}
+	close(work)
+	wg.Wait()
 }
 
 // mkfile creates a single file of specified size or says why not.
-func mkFile(baseURL, sourceFile, fullPath, size string) {
+func mkFile(baseURL, sourceFile, fullPath string, size int64) error {
 	var err error
+	var rc int
 
 	if conf.Debug {
-		log.Printf("in mkFile(baseURL=%s, sourceFile=%s, fullPath=%s, size=%s", baseURL, sourceFile, fullPath, size)
-	}
-	fileSize, err := strconv.ParseInt(size, 10, 64) // FIXME hoist?
-	if err != nil {
-		log.Fatalf("can't get size from %q", size)
+		log.Printf("in mkFile(baseURL=%s, sourceFile=%s, fullPath=%s, size=%d", baseURL, sourceFile, fullPath, size)
 	}
 	switch conf.Protocol {
-	case FilesystemProtocol: // prepend current directory to path
-		err = TimedCreateFilesystemFile("./"+strings.TrimPrefix(fullPath, "/"), fileSize)
-	//case S3Protocol:
-	//	err = AmazonS3Put(baseURL, fullPath, fileSize)
-	//case RESTProtocol:
-	//	err = RestPut("http://"+baseURL, fullPath, fileSize)
+	case FilesystemProtocol: // under the url, or the current directory
+		err = TimedCreateFilesystemFile(FilesystemProto{root: baseURL}.fullPath(fullPath), fullPath, size)
+	case RESTProtocol, S3Protocol:
+		// with the same path runLoadTest will GET
+		op.Put(fullPath, strconv.FormatInt(size, 10), "", options{rc: &rc})
+		if badPutCode(rc) {
+			return fmt.Errorf("could not create %s, the PUT returned %d", fullPath, rc)
+		}
 	//case CephProtocol: // Pre-alpha stage
 	//	err = createCephFile(baseURL+fullPath, fileSize)
 	default:
 		log.Fatalf("Unimplemented protocol %d, halting\n", conf.Protocol)
 	}
 	if err != nil {
-		log.Fatalf(`Fatal error mid-way in %s: "%s" while creating %s of size %s\n`,
+		log.Fatalf(`Fatal error mid-way in %s: "%s" while creating %s of size %d\n`,
 			sourceFile, err, fullPath, size)
 	}
+	return nil
 }
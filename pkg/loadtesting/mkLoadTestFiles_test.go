@@ -0,0 +1,313 @@
+package loadtesting
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// populate is a perf file with things to create and not to create
+const populate = `2024-05-01 10:00:00 0 0 0 1000 /a.jpg 200 GET
+2024-05-01 10:00:01 0 0 0 0 /missing.jpg 404 GET
+2024-05-01 10:00:02 0 0 0 500 /upload.jpg 201 PUT
+2024-05-01 10:00:03 0 0 0 0 /old.jpg 204 DELETE
+2024-05-01 10:00:04 0 0 0 200000 /dir/b.jpg 200 GET
+`
+
+// created are the paths and sizes populate should create
+var created = map[string]int64{"/a.jpg": 1000, "/old.jpg": 0, "/dir/b.jpg": 200000}
+
+// mkPopulate writes populate to a file and opens it
+func mkPopulate(t *testing.T) *os.File {
+	name := filepath.Join(t.TempDir(), "populate.csv")
+	if err := os.WriteFile(name, []byte(populate), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mustOpen(t, name)
+}
+
+// checkPuts checks that the output has a PUT for each of created, and
+// nothing else, under root
+func checkPuts(t *testing.T, out, root string) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != len(created) {
+		t.Errorf("got %d lines, want %d, in %q", len(lines), len(created), out)
+	}
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) < 9 || f[8] != "PUT" || f[7] != "201" {
+			t.Errorf("got %q, want a PUT with 201", line)
+			continue
+		}
+		if _, ok := created[strings.TrimPrefix(f[6], root)]; !ok {
+			t.Errorf("created %s, which wasn't to be created", f[6])
+		}
+	}
+}
+
+// TestMkLoadTestFilesS3 populates a bucket in the S3 stand-in
+func TestMkLoadTestFilesS3(t *testing.T) {
+	withS3(t, Config{}, func(url string) {
+		savedClient, savedAuth := httpClient, auth
+		defer func() { httpClient, auth = savedClient, savedAuth }()
+		cfg := Config{Protocol: S3Protocol, S3Key: "key", S3Secret: "secret", S3Bucket: "test"}
+
+		out := captureStdout(t, func() {
+			MkLoadTestFiles(mkPopulate(t), "populate.csv", url, 0, 100, cfg)
+		})
+		checkPuts(t, out, "")
+		for key, size := range created {
+			head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("test"), Key: aws.String(key)})
+			if err != nil {
+				t.Errorf("HeadObject(%s) failed, %v", key, err)
+				continue
+			}
+			if *head.ContentLength != size {
+				t.Errorf("%s has %d bytes, want %d", key, *head.ContentLength, size)
+			}
+		}
+		_, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("test"), Key: aws.String("/missing.jpg")})
+		if err == nil {
+			t.Errorf("/missing.jpg was created")
+		}
+	})
+}
+
+// TestMkLoadTestFilesREST populates a REST server, the simulator
+func TestMkLoadTestFilesREST(t *testing.T) {
+	s, err := newSimulator(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	savedConf, savedOp, savedClient, savedAuth := conf, op, httpClient, auth
+	defer func() { conf, op, httpClient, auth = savedConf, savedOp, savedClient, savedAuth }()
+
+	out := captureStdout(t, func() {
+		MkLoadTestFiles(mkPopulate(t), "populate.csv", server.URL, 0, 100, Config{Protocol: RESTProtocol})
+	})
+	checkPuts(t, out, "")
+	for path, size := range created {
+		// the url is prefix + "/" + path, as runLoadTest GETs it
+		body, ok := s.stored["/"+path]
+		if !ok || int64(len(body)) != size {
+			t.Errorf("%s has %d bytes, stored=%v, want %d", path, len(body), ok, size)
+		}
+	}
+	if _, ok := s.stored["//missing.jpg"]; ok {
+		t.Errorf("/missing.jpg was created")
+	}
+}
+
+// TestMkLoadTestFilesFS populates a directory
+func TestMkLoadTestFilesFS(t *testing.T) {
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+	root := t.TempDir()
+
+	out := captureStdout(t, func() {
+		MkLoadTestFiles(mkPopulate(t), "populate.csv", root, 0, 100, Config{Protocol: FilesystemProtocol})
+	})
+	checkPuts(t, out, root)
+	for path, size := range created {
+		info, err := os.Stat(filepath.Join(root, path))
+		if err != nil || info.Size() != size {
+			t.Errorf("%s: got %v, %v, want %d bytes", path, info, err, size)
+		}
+	}
+}
+
+// TestMkLoadTestFilesManifest creates each path once, at its largest
+// size, lists it in the manifest, and resumes from the manifest
+func TestMkLoadTestFilesManifest(t *testing.T) {
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+	root := t.TempDir()
+	name := filepath.Join(t.TempDir(), "records.csv")
+	records := `2024-05-01 10:00:00 0 0 0 1000 /a 200 GET
+2024-05-01 10:00:01 0 0 0 10 /b 200 GET
+2024-05-01 10:00:02 0 0 0 3000 /a 200 GET
+2024-05-01 10:00:03 0 0 0 20 /c 200 GET
+2024-05-01 10:00:04 0 0 0 0 /b 304 GET
+`
+	if err := os.WriteFile(name, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"/a": 3000, "/b": 10, "/c": 20}
+	manifestName := filepath.Join(t.TempDir(), "manifest")
+	cfg := Config{Protocol: FilesystemProtocol, MkWorkers: 3, MkManifest: manifestName}
+
+	out := captureStdout(t, func() {
+		MkLoadTestFiles(mustOpen(t, name), name, root, 0, 100, cfg)
+	})
+	if n := strings.Count(out, " 201 PUT "); n != len(want) {
+		t.Errorf("got %d PUTs, want %d, in %q", n, len(want), out)
+	}
+	listed := readManifest(t, manifestName)
+	if data, _ := os.ReadFile(manifestName); !strings.HasPrefix(string(data), "#path size sha256 content=random\n") {
+		t.Errorf("the manifest starts %q, want a header with the kind of content", data)
+	}
+	for path, size := range want {
+		data, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil || int64(len(data)) != size {
+			t.Errorf("%s: got %d bytes, %v, want %d", path, len(data), err, size)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if got := listed[path]; got != fmt.Sprintf("%d %x", size, sum) {
+			t.Errorf("%s: manifest has %q, want %d %x", path, got, size, sum)
+		}
+	}
+
+	// resuming after everything is done does nothing
+	cfg.MkResume = true
+	out = captureStdout(t, func() {
+		MkLoadTestFiles(mustOpen(t, name), name, root, 0, 100, cfg)
+	})
+	if out != "" {
+		t.Errorf("resuming a complete run printed %q, want nothing", out)
+	}
+
+	// and after a crash creates only what's missing, or the wrong size
+	manifest := "#path size sha256\n/a 3000 x\n/b 5 x\n/c 20"
+	if err := os.WriteFile(manifestName, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out = captureStdout(t, func() {
+		MkLoadTestFiles(mustOpen(t, name), name, root, 0, 100, cfg)
+	})
+	if strings.Count(out, " 201 PUT ") != 2 || !strings.Contains(out, " 10 "+root+"/b 201 PUT") ||
+		!strings.Contains(out, " 20 "+root+"/c 201 PUT") {
+		t.Errorf("resuming a partial run printed %q, want PUTs of /b and /c", out)
+	}
+
+	// which finished the crash's last line, so resuming again does nothing
+	out = captureStdout(t, func() {
+		MkLoadTestFiles(mustOpen(t, name), name, root, 0, 100, cfg)
+	})
+	if out != "" {
+		t.Errorf("resuming a second time printed %q, want nothing", out)
+	}
+	data, err := os.ReadFile(manifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\n/c 20\n/") {
+		t.Errorf("the manifest is %q, want the crash's last line ended", data)
+	}
+}
+
+// TestMkLoadTestFilesFailedPut checks that a file the server wouldn't
+// take isn't in the manifest, so a resume tries it again
+func TestMkLoadTestFilesFailedPut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // nolint
+		switch {
+		case r.Method != "PUT":
+			// the check that the server is there
+		case strings.HasSuffix(r.URL.Path, "/b"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	savedConf, savedOp, savedClient, savedAuth := conf, op, httpClient, auth
+	defer func() { conf, op, httpClient, auth = savedConf, savedOp, savedClient, savedAuth }()
+	name := filepath.Join(t.TempDir(), "records.csv")
+	records := "2024-05-01 10:00:00 0 0 0 10 /a 200 GET\n2024-05-01 10:00:01 0 0 0 20 /b 200 GET\n"
+	if err := os.WriteFile(name, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifestName := filepath.Join(t.TempDir(), "manifest")
+	cfg := Config{Protocol: RESTProtocol, MkManifest: manifestName}
+
+	out := captureStdout(t, func() {
+		MkLoadTestFiles(mustOpen(t, name), name, server.URL, 0, 100, cfg)
+	})
+	if !strings.Contains(out, " 500 PUT ") {
+		t.Errorf("got %q, want the PUT of /b to fail", out)
+	}
+	listed := readManifest(t, manifestName)
+	if _, ok := listed["/a"]; !ok || len(listed) != 1 {
+		t.Errorf("the manifest lists %v, want only /a", listed)
+	}
+}
+
+// TestOpenManifestContent checks that a manifest is only resumed with
+// the kind of content it lists
+func TestOpenManifestContent(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "manifest")
+	m, err := openManifest(name, "compressible", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Close() // nolint
+
+	tests := []struct {
+		content string
+		ok      bool
+	}{
+		{"compressible", true},
+		{"random", false},
+		{"", false}, // which is random
+	}
+	for _, test := range tests {
+		m, err := openManifest(name, test.content, true)
+		if (err == nil) != test.ok {
+			t.Errorf("resuming a compressible manifest with %q content, got %v, want ok = %v",
+				test.content, err, test.ok)
+		}
+		if err == nil {
+			m.Close() // nolint
+		}
+	}
+}
+
+// readManifest reads a manifest into a map of path to "size sum"
+func readManifest(t *testing.T, name string) map[string]string {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			t.Errorf("bad manifest line %q", line)
+			continue
+		}
+		listed[f[0]] = f[1] + " " + f[2]
+	}
+	return listed
+}
+
+// TestMkLoadTestFilesRate checks that -rate limits the workers
+func TestMkLoadTestFilesRate(t *testing.T) {
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+	cfg := Config{Protocol: FilesystemProtocol, MkWorkers: 4, MkRate: 20}
+
+	start := time.Now()
+	captureStdout(t, func() {
+		MkLoadTestFiles(mkPopulate(t), "populate.csv", t.TempDir(), 0, 100, cfg)
+	})
+	// three files at 20/second, the first after 50ms
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("created 3 files at 20/second in %s, want about 150ms", elapsed)
+	}
+}
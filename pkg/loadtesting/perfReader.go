@@ -0,0 +1,263 @@
+package loadtesting
+
+// Read "perf" format files, the common input of runLoadTest,
+// mkLoadTestFiles and describe.
+// input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 404 GET"
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Records may carry key=value options after the body field, such as
+//
+//	2017-09-21 08:15:07.270 0 0 0 0 /a.jpg 200 GET "" "header=Accept: image/jpeg"
+//
+// or, to capture a value from the response for later records in the
+// same session to use as ${id}, see extract.go,
+//
+//	2017-09-21 08:15:07.270 0 0 0 9 /items 201 POST "{}" session=u1 "extract=id:json:item.id"
+//
+// or, to misbehave as a client, see restFaults.go,
+//
+//	2017-09-21 08:15:07.270 0 0 0 0 /a.jpg 200 GET "" fault=slow-read
+//
+// Unrecognized options, like the expectedRC= annotation in our own
+// output, are ignored.
+const optionsField = bodyField + 1
+
+// options are the parsed key=value fields of a record
+type options struct {
+	headers  http.Header  // header=Name: value
+	session  string       // session=name
+	extracts []extraction // extract=name:kind:expression
+	fault    string       // fault=kind
+	store    varStore     // where extracts go, set when the record is run
+	client   *http.Client // the worker's own client, if it has one
+	rc       *int         // where a PUT leaves its return code, if anyone asks
+}
+
+// setRC leaves rc where o asks for it, if it does
+func (o options) setRC(rc int) {
+	if o.rc != nil {
+		*o.rc = rc
+	}
+}
+
+// httpClient returns the client to send a record with
+func (o options) httpClient() *http.Client {
+	if o.client != nil {
+		return o.client
+	}
+	return httpClient
+}
+
+// recordOptions parses the options, if any, from a record
+func recordOptions(r []string) options {
+	var o options
+
+	for i := optionsField; i < len(r); i++ {
+		key, value, found := strings.Cut(r[i], "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "header":
+			name, v, ok := strings.Cut(value, ":")
+			if !ok || name == "" {
+				continue
+			}
+			if o.headers == nil {
+				o.headers = make(http.Header)
+			}
+			o.headers.Add(strings.TrimSpace(name), strings.TrimSpace(v))
+		case "session":
+			o.session = value
+		case "extract":
+			e, err := parseExtraction(value)
+			if err != nil {
+				log.Printf("ignoring option %q, %v\n", r[i], err)
+				continue
+			}
+			o.extracts = append(o.extracts, e)
+		case "fault":
+			if !knownFault(value) {
+				log.Printf("ignoring option %q, the faults are %s\n", r[i],
+					strings.Join(faultKinds, ", "))
+				continue
+			}
+			o.fault = value
+		}
+	}
+	return o
+}
+
+// fields returns the options in key=value form, for writing after a body
+func (o options) fields() []string {
+	var f []string
+
+	for _, name := range sortedHeaderNames(o.headers) {
+		for _, v := range o.headers[name] {
+			f = append(f, "header="+name+": "+v)
+		}
+	}
+	if o.session != "" {
+		f = append(f, "session="+o.session)
+	}
+	for _, e := range o.extracts {
+		f = append(f, "extract="+e.String())
+	}
+	if o.fault != "" {
+		f = append(f, "fault="+o.fault)
+	}
+	return f
+}
+
+// sortedHeaderNames returns the names of some headers in a stable order
+func sortedHeaderNames(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recordReader is anything that can supply perf records, one at a time.
+// It returns io.EOF at the end of its input, like csv.Reader
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// newRecordReader makes a reader for the named input format
+func newRecordReader(format string, f io.Reader) (recordReader, error) {
+	switch format {
+	case "", "perf":
+		return newPerfReader(f), nil
+	case "har":
+		return newHARReader(f)
+	case "jtl":
+		return newJTLReader(f)
+	case "alb", "elb":
+		return newALBReader(f), nil
+	case "cloudfront":
+		return newCloudFrontReader(f), nil
+	case "s3log":
+		return newS3LogReader(f), nil
+	default:
+		return nil, fmt.Errorf("unknown input format %q", format)
+	}
+}
+
+// newPerfReader makes a csv reader that understands the perf format
+func newPerfReader(f io.Reader) *csv.Reader {
+	r := csv.NewReader(f)
+	r.Comma = ' '
+	r.Comment = '#'
+	r.FieldsPerRecord = -1 // ignore differences
+	return r
+}
+
+// recordWriter is anything that can write perf records in some format.
+// Close flushes anything buffered, but does not close the underlying file.
+type recordWriter interface {
+	Write(record []string) error
+	Close() error
+}
+
+// newRecordWriter makes a writer for the named output format
+func newRecordWriter(format string, w io.Writer, baseURL string) (recordWriter, error) {
+	switch format {
+	case "", "perf":
+		return newPerfWriter(w), nil
+	case "har":
+		return newHARWriter(w, baseURL), nil
+	case "seconds":
+		return newSecondsWriter(w, conf.PerLabel), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// perfWriter writes perf records, quoting fields that contain spaces
+type perfWriter struct {
+	w *csv.Writer
+}
+
+// newPerfWriter makes a perfWriter, and writes the customary header
+func newPerfWriter(w io.Writer) *perfWriter {
+	fmt.Fprint(w, "#yyy-mm-dd hh:mm:ss latency xfertime sleeptime bytes url rc op body\n") // nolint
+	cw := csv.NewWriter(w)
+	cw.Comma = ' '
+	return &perfWriter{w: cw}
+}
+
+// Write writes one record
+func (p *perfWriter) Write(record []string) error {
+	return p.w.Write(record)
+}
+
+// Close flushes the records written
+func (p *perfWriter) Close() error {
+	p.w.Flush()
+	return p.w.Error()
+}
+
+// makeRecord builds a perf record from its parts, with times in seconds
+func makeRecord(t time.Time, latency, xferTime float64, bytes int64,
+	path string, rc int, operator, body string) []string {
+	return []string{
+		t.Format("2006-01-02"),
+		t.Format("15:04:05.000"),
+		strconv.FormatFloat(latency, 'f', -1, 64),
+		strconv.FormatFloat(xferTime, 'f', -1, 64),
+		"0",
+		strconv.FormatInt(bytes, 10),
+		path,
+		strconv.Itoa(rc),
+		operator,
+		body,
+	}
+}
+
+// lineOf returns the input line of the last record read, if the
+// reader can tell us. Otherwise it returns 0.
+func lineOf(r recordReader) int {
+	pos, ok := r.(interface {
+		FieldPos(field int) (line, column int)
+	})
+	if !ok {
+		return 0
+	}
+	line, _ := pos.FieldPos(0)
+	return line
+}
+
+// recordTimeLayouts are the date and time formats we've seen in perf files
+// Fractional seconds are accepted by time.Parse without being in the layout.
+var recordTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"02-Jan-2006 15:04:05",
+	"2006-Jan-02 15:04:05",
+	"02/Jan/2006 15:04:05",
+	"2006/01/02 15:04:05",
+}
+
+// recordTime parses the date and time fields of a record
+func recordTime(date, clock string) (time.Time, error) {
+	s := date + " " + clock
+	for _, layout := range recordTimeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date and time %q", s)
+}
@@ -0,0 +1,172 @@
+package loadtesting
+
+// Record live traffic: a reverse proxy that forwards each request to a
+// real upstream and writes the exchange as a perf record, with the latency
+// and transfer time it measured. This is the "record" half of record/replay,
+// for when the server's own logs lack the fields we need.
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hopByHopHeaders are for one connection only, so a proxy mustn't forward them
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// recorder satisfies http.Handler by proxying to upstream and recording
+type recorder struct {
+	upstream    *url.URL
+	client      *http.Client
+	keepBodies  bool
+	keepHeaders []string
+	mu          sync.Mutex // serializes writes to w
+	w           *perfWriter
+}
+
+// Record listens on addr, forwarding to upstream and writing perf
+// records to stdout until killed
+func Record(addr, upstream string, cfg Config) {
+	conf = cfg
+	u, err := url.Parse(upstream)
+	if err != nil || u.Host == "" {
+		log.Fatalf("upstream %q is not a usable url, %v, halting\n", upstream, err)
+	}
+	rec := newRecorder(u, os.Stdout, conf.RecordBodies, conf.RecordHeaders)
+	log.Printf("Recording requests to %s, forwarding them to %s\n", addr, upstream)
+	log.Fatal(http.ListenAndServe(addr, rec))
+}
+
+// newRecorder makes a recorder that writes to w
+func newRecorder(upstream *url.URL, w io.Writer, keepBodies bool, keepHeaders []string) *recorder {
+	return &recorder{
+		upstream: upstream,
+		client: &http.Client{
+			// let the client see redirects, as it would without us
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		keepBodies:  keepBodies,
+		keepHeaders: keepHeaders,
+		w:           newPerfWriter(w),
+	}
+}
+
+// ServeHTTP forwards one request, copies back the response and records both
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	var err error
+
+	if conf.Debug {
+		log.Printf("in recorder.ServeHTTP(%s %s)\n", r.Method, r.URL)
+	}
+	// we need the request size for PUTs, and the body if we're keeping it
+	sent := &countingReader{r: r.Body}
+	var reqBody io.Reader = sent
+	if rec.keepBodies {
+		body, err = io.ReadAll(sent)
+		if err != nil {
+			http.Error(w, "error reading request body", http.StatusBadRequest)
+			return
+		}
+		reqBody = bytes.NewReader(body)
+	}
+
+	target := *rec.upstream
+	target.Path = strings.TrimSuffix(rec.upstream.Path, "/") + r.URL.Path
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+	out, err := http.NewRequest(r.Method, target.String(), reqBody)
+	if err != nil {
+		http.Error(w, "error creating upstream request", http.StatusBadGateway)
+		return
+	}
+	out.Header = r.Header.Clone()
+	for _, h := range hopByHopHeaders {
+		out.Header.Del(h)
+	}
+	if !rec.keepBodies {
+		out.ContentLength = r.ContentLength
+		if r.ContentLength == 0 {
+			out.Body = http.NoBody // or it would be sent chunked
+		}
+	}
+
+	initial := time.Now() // Response time starts
+	resp, err := rec.client.Do(out)
+	latency := time.Since(initial) // Latency ends
+	if err != nil {
+		log.Printf("error forwarding %s %s, %v\n", r.Method, r.URL, err)
+		w.WriteHeader(http.StatusBadGateway)
+		rec.record(r, initial, latency, 0, sent.n, 0, http.StatusBadGateway, body)
+		return
+	}
+	defer resp.Body.Close() // nolint
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	for _, h := range hopByHopHeaders {
+		w.Header().Del(h)
+	}
+	w.WriteHeader(resp.StatusCode)
+	received, err := io.Copy(w, resp.Body)
+	transferTime := time.Since(initial) - latency // Transfer time ends
+	if err != nil {
+		log.Printf("error copying response to %s %s, %v\n", r.Method, r.URL, err)
+	}
+	rec.record(r, initial, latency, transferTime, sent.n, received, resp.StatusCode, body)
+}
+
+// record writes one exchange. PUTs and POSTs report the bytes sent,
+// which is what runLoadTest needs to replay them, everything else the
+// bytes received
+func (rec *recorder) record(r *http.Request, initial time.Time, latency, transferTime time.Duration,
+	sent, received int64, rc int, body []byte) {
+	size := received
+	if r.Method == "PUT" || r.Method == "POST" {
+		size = sent
+	}
+	record := makeRecord(initial, latency.Seconds(), transferTime.Seconds(), size,
+		r.URL.RequestURI(), rc, r.Method, string(body))
+
+	var o options
+	for _, name := range rec.keepHeaders {
+		name = http.CanonicalHeaderKey(name)
+		for _, v := range r.Header.Values(name) {
+			if o.headers == nil {
+				o.headers = make(http.Header)
+			}
+			o.headers.Add(name, v)
+		}
+	}
+	record = append(record, o.fields()...)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.w.Write(record); err != nil {
+		log.Printf("error recording %q, %v\n", record, err)
+	}
+	// flush each one, so the file can be tailed by runLoadTest --tail
+	rec.w.w.Flush()
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read reads and counts
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
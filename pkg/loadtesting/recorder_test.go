@@ -0,0 +1,70 @@
+package loadtesting
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestRecorder proxies to an echo server like cmd/dummy, and checks the records
+func TestRecorder(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		fmt.Fprintf(w, "Got %q %q\n", r.URL.Path[len("/"):], body) // nolint
+	}))
+	defer upstream.Close()
+
+	var out bytes.Buffer
+	u, _ := url.Parse(upstream.URL)
+	proxy := httptest.NewServer(newRecorder(u, &out, true, []string{"content-type"}))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/hello?x=1")
+	if err != nil {
+		t.Fatalf("GET through the recorder failed, %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close() // nolint
+	if string(got) != "Got \"hello\" \"\"\n" {
+		t.Errorf("GET through the recorder returned %q", got)
+	}
+	resp, err = http.Post(proxy.URL+"/cart", "application/json", strings.NewReader(`{"id": 42}`))
+	if err != nil {
+		t.Fatalf("POST through the recorder failed, %v", err)
+	}
+	resp.Body.Close() // nolint
+
+	r := newPerfReader(&out)
+	var tests = []struct {
+		path, rc, op, bytes, body, contentType string
+	}{
+		{"/hello?x=1", "200", "GET", fmt.Sprint(len(got)), "", ""},
+		{"/cart", "201", "POST", "10", `{"id": 42}`, "application/json"},
+	}
+	for _, test := range tests {
+		record, err := r.Read()
+		if err != nil {
+			t.Fatalf("could not read the recording, %v", err)
+		}
+		if err = checkRecord(record); err != nil {
+			t.Errorf("record %q is malformed, %v", record, err)
+		}
+		if record[pathField] != test.path || record[returnCodeField] != test.rc ||
+			record[operatorField] != test.op || record[bytesField] != test.bytes ||
+			record[bodyField] != test.body {
+			t.Errorf("got record %q, expected %s %s %s %s %q", record,
+				test.bytes, test.path, test.rc, test.op, test.body)
+		}
+		if ct := recordOptions(record).headers.Get("Content-Type"); ct != test.contentType {
+			t.Errorf("recorded content-type %q, expected %q", ct, test.contentType)
+		}
+	}
+}
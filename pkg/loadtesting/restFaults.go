@@ -0,0 +1,327 @@
+package loadtesting
+
+// restFaults has RestProto misbehave as real clients do, to see how a
+// server copes under load when some of them do. A record can ask for a
+// fault with a fault=kind option, or --faults can give each kind a
+// fraction of the traffic. The kinds are
+//
+//	slow-read   read the response at --fault-read-rate bytes/second
+//	abort       read half the response, then hang up, as nginx's 499s
+//	truncate    send half the request, then hang up
+//	big-header  send a --fault-header-size header
+//	malformed   send a request with a line that isn't a header
+//
+// Results are reported as usual, with a fault=kind annotation. When we
+// hang up, the return code is nginx's 499, client closed request.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// faultKinds are the faults we can commit
+var faultKinds = []string{"slow-read", "abort", "truncate", "big-header", "malformed"}
+
+// These are the defaults of the fault options
+const (
+	defaultFaultReadRate   = 1024      // bytes/second
+	defaultFaultHeaderSize = 64 * 1024 // bytes
+)
+
+// clientClosed is nginx's code for a client that gave up
+const clientClosed = 499
+
+// faultRate is a fault and the fraction of requests to commit it in
+type faultRate struct {
+	kind string
+	rate float64
+}
+
+// faults are the rates from --faults, if any
+var faults []faultRate
+
+// knownFault is true if kind is one of faultKinds
+func knownFault(kind string) bool {
+	for _, k := range faultKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFaults parses "kind:rate;...", as in "slow-read:0.05;abort:0.01"
+func parseFaults(s string) ([]faultRate, error) {
+	var rates []faultRate
+	var total float64
+
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		kind, fraction, found := strings.Cut(spec, ":")
+		if !found || !knownFault(kind) {
+			return nil, fmt.Errorf("fault %q is not kind:rate, with a kind of %s", spec,
+				strings.Join(faultKinds, ", "))
+		}
+		rate, err := strconv.ParseFloat(fraction, 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("fault rate %q is not a fraction", fraction)
+		}
+		total += rate
+		rates = append(rates, faultRate{kind: kind, rate: rate})
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("fault rates add up to %g, more than 1", total)
+	}
+	return rates, nil
+}
+
+// chooseFault returns the record's fault, or one chosen at random at
+// the --faults rates, or "" to behave
+func (o options) chooseFault() string {
+	if o.fault != "" {
+		return o.fault
+	}
+	x := rand.Float64()
+	for _, f := range faults {
+		if x < f.rate {
+			return f.kind
+		}
+		x -= f.rate
+	}
+	return ""
+}
+
+// misbehave does a request with a fault. body is the request body, or
+// nil for none.
+func (p RestProto) misbehave(fault, method, path string, body []byte, oldRc string, o options) {
+	if conf.Debug {
+		log.Printf("in rest.misbehave(%s, %s, %s)\n", fault, method, path)
+	}
+	switch fault {
+	case "slow-read", "abort", "big-header":
+		p.badClient(fault, method, path, body, oldRc, o)
+	case "truncate", "malformed":
+		p.badRequest(fault, method, path, body, oldRc, o)
+	}
+}
+
+// badClient sends a request with the usual client, then reads the
+// response slowly or not at all
+func (p RestProto) badClient(fault, method, path string, body []byte, oldRc string, o options) {
+	var bodyReader io.Reader
+	var read int64
+
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, p.prefix+"/"+strings.TrimPrefix(path, "/"), bodyReader)
+	if err != nil {
+		dumpXact(req, nil, nil, conf.Crash, "error creating http request", err)
+		reportFault(time.Now(), 0, 0, 0, path, -1, method, fault, oldRc, nil)
+		return
+	}
+	addHeaders(req, o)
+	req, timing := withTiming(req)
+	if fault == "big-header" {
+		size := conf.FaultHeaderSize
+		if size <= 0 {
+			size = defaultFaultHeaderSize
+		}
+		req.Header.Set("X-Oversized", strings.Repeat("x", size))
+	}
+
+	initial := time.Now() // Response time starts
+	resp, err := o.httpClient().Do(req)
+	latency := time.Since(initial) // Latency ends
+	if err != nil {
+		dumpXact(req, nil, nil, conf.Crash, "error getting http response", err)
+		reportFault(initial, latency, 0, 0, path, 444, method, fault, oldRc, timing)
+		return
+	}
+	timing.setProto(resp.Proto)
+	rc := resp.StatusCode
+	switch fault {
+	case "slow-read":
+		read, err = slowRead(resp.Body)
+	case "abort":
+		// read half of it, or one byte of a body of unknown length
+		half := resp.ContentLength / 2
+		if half <= 0 {
+			half = 1
+		}
+		read, err = io.CopyN(io.Discard, resp.Body, half)
+		if err == nil {
+			rc = clientClosed
+		}
+	default:
+		read, err = io.Copy(io.Discard, resp.Body)
+	}
+	resp.Body.Close()                             // nolint, closing early hangs up
+	transferTime := time.Since(initial) - latency // Transfer time ends
+	if err != nil && err != io.EOF {
+		dumpXact(req, resp, nil, conf.Crash, "error reading http response", err)
+	}
+	reportFault(initial, latency, transferTime, read, path, rc, method, fault, oldRc, timing)
+}
+
+// slowRead reads r at --fault-read-rate
+func slowRead(r io.Reader) (int64, error) {
+	var total int64
+
+	rate := conf.FaultReadRate
+	if rate <= 0 {
+		rate = defaultFaultReadRate
+	}
+	// a tenth of a second's worth at a time, so it's a trickle, not bursts
+	buf := make([]byte, rate/10+1)
+	for {
+		n, err := r.Read(buf)
+		total += int64(n)
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(rate))
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// badRequest writes a request no well-behaved client would on a new
+// connection. A truncated one is cut off half-way, the headers if
+// there's no body, and we hang up. A malformed one is complete but
+// has a line that isn't a header, and we wait for the server's answer.
+func (p RestProto) badRequest(fault, method, path string, body []byte, oldRc string, o options) {
+	req, err := http.NewRequest(method, p.prefix+"/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		dumpXact(req, nil, nil, conf.Crash, "error creating http request", err)
+		reportFault(time.Now(), 0, 0, 0, path, -1, method, fault, oldRc, nil)
+		return
+	}
+	addHeaders(req, o)
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "%s %s HTTP/1.1\r\nHost: %s\r\n", method, req.URL.RequestURI(), host)
+	if body != nil {
+		fmt.Fprintf(&text, "Content-Length: %d\r\n", len(body))
+	}
+	req.Header.Del("Host")
+	req.Header.Write(&text) // nolint
+	if fault == "malformed" {
+		text.WriteString("this is not a header\r\n")
+	}
+	text.WriteString("\r\n")
+	headerLen := text.Len()
+	text.Write(body)
+
+	timing := &requestTiming{}
+	initial := time.Now() // Response time starts
+	conn, err := dialURL(req.URL, timing)
+	if err != nil {
+		log.Printf("could not connect to %s, %v\n", req.URL.Host, err)
+		reportFault(initial, time.Since(initial), 0, 0, path, 444, method, fault, oldRc, timing)
+		return
+	}
+	defer conn.Close() // nolint
+	if conf.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(conf.Timeout)) // nolint
+	}
+
+	if fault == "truncate" {
+		cut := headerLen / 2
+		if body != nil {
+			cut = headerLen + len(body)/2
+		}
+		n, err := conn.Write(text.Bytes()[:cut])
+		latency := time.Since(initial)
+		if err != nil {
+			log.Printf("error writing a truncated request to %s, %v\n", req.URL.Host, err)
+		}
+		reportFault(initial, latency, 0, int64(n), path, clientClosed, method, fault, oldRc, timing)
+		return
+	}
+
+	if _, err = conn.Write(text.Bytes()); err != nil {
+		log.Printf("error writing a malformed request to %s, %v\n", req.URL.Host, err)
+		reportFault(initial, time.Since(initial), 0, 0, path, 444, method, fault, oldRc, timing)
+		return
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	latency := time.Since(initial) // Latency ends
+	if err != nil {
+		// 444 is nginx's code for server has returned no information and/or EOF
+		reportFault(initial, latency, 0, 0, path, 444, method, fault, oldRc, timing)
+		return
+	}
+	timing.setProto(resp.Proto)
+	read, _ := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close() // nolint
+	reportFault(initial, latency, time.Since(initial)-latency, read, path, resp.StatusCode,
+		method, fault, oldRc, timing)
+}
+
+// dialURL connects to the host of u, with tls for https, recording the
+// connect and handshake times in timing as httptrace would
+func dialURL(u *url.URL, timing *requestTiming) (net.Conn, error) {
+	dialTimeout := conf.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 30 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil || u.Scheme != "https" {
+		timing.connect = time.Since(start)
+		return conn, err
+	}
+	timing.connect = time.Since(start)
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = u.Hostname()
+	}
+	start = time.Now()
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close() // nolint
+		return nil, err
+	}
+	timing.tlsHandshake = time.Since(start)
+	return tlsConn, nil
+}
+
+// reportFault prints the result of a request with a fault, as usual, with
+// the kind of fault after everything else
+func reportFault(initial time.Time, latency, transferTime time.Duration, size int64,
+	path string, rc int, method, fault, oldRc string, timing *requestTiming) {
+	reportPerformance(initial, latency, transferTime, size, path, rc, method, oldRc, timing, " fault="+fault)
+}
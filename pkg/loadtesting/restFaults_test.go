@@ -0,0 +1,122 @@
+package loadtesting
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRestFaults has RestProto commit each fault against a server, and
+// checks what's reported
+func TestRestFaults(t *testing.T) {
+	content := strings.Repeat("x", 4000)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			io.WriteString(w, content) // nolint
+			return
+		}
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			return // a truncated upload
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	server.Config.MaxHeaderBytes = 4096
+	server.Start()
+	defer server.Close()
+
+	savedConf, savedFaults := conf, faults
+	defer func() { conf, faults = savedConf, savedFaults }()
+	conf = Config{FaultReadRate: 20000, Timeout: 5 * time.Second}
+	faults = nil
+	p := RestProto{prefix: server.URL}
+
+	tests := []struct {
+		fault, method string
+		rc            int
+		bytes         int
+	}{
+		{"slow-read", "GET", 200, 4000},
+		{"abort", "GET", 499, 2000},
+		{"truncate", "GET", 499, -1},
+		{"truncate", "PUT", 499, -1},
+		{"big-header", "GET", 431, -1},
+		{"malformed", "GET", 400, -1},
+		{"malformed", "PUT", 400, -1},
+	}
+	for _, test := range tests {
+		o := options{fault: test.fault}
+		out := captureStdout(t, func() {
+			if test.method == "GET" {
+				p.Get("file", "200", o)
+			} else {
+				p.Put("file", "4000", "201", o)
+			}
+		})
+		f := strings.Fields(out)
+		if len(f) < 11 || f[8] != test.method || f[bodyField] != strconv.Itoa(ExpectedRate) ||
+			f[len(f)-1] != "fault="+test.fault {
+			t.Errorf("%s %s: got %q, want a %s line with the rate, then fault=%s",
+				test.fault, test.method, out, test.method, test.fault)
+			continue
+		}
+		if rc, _ := strconv.Atoi(f[7]); rc != test.rc {
+			t.Errorf("%s %s: got rc %d, want %d", test.fault, test.method, rc, test.rc)
+		}
+		if n, _ := strconv.Atoi(f[5]); test.bytes >= 0 && n != test.bytes {
+			t.Errorf("%s %s: got %d bytes, want %d", test.fault, test.method, n, test.bytes)
+		}
+		if test.fault == "slow-read" {
+			// 4000 bytes at 20000 bytes/second
+			if xfer, _ := strconv.ParseFloat(f[3], 64); xfer < 0.15 {
+				t.Errorf("slow-read took %gs to read, want about 0.2s", xfer)
+			}
+		}
+	}
+
+	// and chosen by rate, not by the record
+	faults = []faultRate{{kind: "abort", rate: 1}}
+	out := captureStdout(t, func() { p.Get("file", "200", options{}) })
+	if !strings.Contains(out, fmt.Sprintf(" 499 GET %d expectedRC=200 ", ExpectedRate)) ||
+		!strings.HasSuffix(strings.TrimSpace(out), " fault=abort") {
+		t.Errorf("with abort:1, got %q, want an abort", out)
+	}
+}
+
+// TestParseFaults checks the --faults syntax
+func TestParseFaults(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []faultRate
+		ok   bool
+	}{
+		{"", nil, true},
+		{"slow-read:0.05; abort:0.01", []faultRate{{"slow-read", 0.05}, {"abort", 0.01}}, true},
+		{"truncate:1", []faultRate{{"truncate", 1}}, true},
+		{"hang:0.1", nil, false},
+		{"abort", nil, false},
+		{"abort:lots", nil, false},
+		{"abort:0.6;truncate:0.6", nil, false},
+	}
+	for _, test := range tests {
+		got, err := parseFaults(test.s)
+		if (err == nil) != test.ok {
+			t.Errorf("parseFaults(%q) error %v, want ok=%v", test.s, err, test.ok)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("parseFaults(%q) = %v, want %v", test.s, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("parseFaults(%q) = %v, want %v", test.s, got, test.want)
+			}
+		}
+	}
+}
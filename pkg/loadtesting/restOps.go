@@ -8,7 +8,6 @@ import (
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -26,8 +25,9 @@ func (p RestProto) Init() {
 
 	req, err := http.NewRequest("GET", p.prefix, nil)
 	if err != nil {
-		log.Fatalf("the http root request could not be created, req = %q err = %v\n", req, err)
+		log.Fatalf("the http root request could not be created, req = %v err = %v\n", req, err)
 	}
+	addHeaders(req, options{})
 	// If this seems to take forever, you may have an error in nginx,
 	// which has seen to hang the load generator in the next line.
 	resp, err := httpClient.Do(req)
@@ -61,6 +61,7 @@ const (
 	RequestTimeout     int = 0
 )
 
+// httpClient is replaced by one built from the configuration, see transport.go
 var httpClient = &http.Client{
 	Transport: &http.Transport{
 		MaxIdleConnsPerHost: MaxIdleConnections,
@@ -69,27 +70,33 @@ var httpClient = &http.Client{
 }
 
 // Get does a GET from an http target and times it
-func (p RestProto) Get(path string, oldRc string) {
+func (p RestProto) Get(path string, oldRc string, o options) {
 	if conf.Debug {
 		log.Printf("in rest.Get(%s)\n", path)
 	}
+	if fault := o.chooseFault(); fault != "" {
+		p.misbehave(fault, "GET", path, nil, oldRc, o)
+		return
+	}
 	req, err := http.NewRequest("GET", p.prefix+"/"+path, nil)
 	if err != nil {
 		dumpXact(req, nil, nil, conf.Crash, "error creating http request", err)
-		reportPerformance(time.Now(), 0, 0, nil, path, -1, oldRc)
+		reportPerformance(time.Now(), 0, 0, 0, path, -1, "GET", oldRc, nil, "")
 		return
 	}
-	addHeaders(req)
+	addHeaders(req, o)
+	req, timing := withTiming(req)
 
 	initial := time.Now() // Response time starts
-	resp, err := httpClient.Do(req)
+	resp, err := o.httpClient().Do(req)
 	latency := time.Since(initial) // Latency ends
 	if err != nil {
 		dumpXact(req, resp, nil, conf.Crash, "error getting http response", err)
 		// 444 is nginx's code for server has returned no information and/or EOF
-		reportPerformance(initial, latency, 0, nil, path, 444, oldRc)
+		reportPerformance(initial, latency, 0, 0, path, 444, "GET", oldRc, timing, "")
 		return
 	}
+	timing.setProto(resp.Proto)
 	body, err := ioutil.ReadAll(resp.Body)
 	// how about io.Copy(ioutil.Discard, resp.Body)
 	transferTime := time.Since(initial) - latency // Transfer time ends
@@ -97,7 +104,8 @@ func (p RestProto) Get(path string, oldRc string) {
 	if err != nil {
 		dumpXact(req, resp, body, conf.Crash, "error reading http response, continuing", err)
 		// the resp is available, the body, distinctly less so (;-))
-		reportPerformance(initial, latency, transferTime, body, path, resp.StatusCode, oldRc)
+		reportPerformance(initial, latency, transferTime, int64(len(body)), path, resp.StatusCode, "GET", oldRc,
+			timing, "")
 		return
 	}
 
@@ -108,12 +116,17 @@ func (p RestProto) Get(path string, oldRc string) {
 	case conf.Verbose:
 		dumpXact(req, resp, body, conf.Crash, "verbose", nil)
 	}
+	o.extract(path, resp.Header, body)
 
-	reportPerformance(initial, latency, transferTime, body, path, resp.StatusCode, oldRc)
+	reportPerformance(initial, latency, transferTime, int64(len(body)), path, resp.StatusCode, "GET", oldRc,
+		timing, verifyAnnotation(path, resp.StatusCode, body))
 }
 
-// AddHeaders adds/drops specified headers
-func addHeaders(req *http.Request) {
+// AddHeaders adds/drops specified headers, starting with the ones from the record
+func addHeaders(req *http.Request, o options) {
+	for key, values := range o.headers {
+		req.Header[key] = append(req.Header[key], values...)
+	}
 	if !conf.Cache {
 		req.Header.Add("cache-control", "no-cache")
 	}
@@ -136,10 +149,19 @@ func addHeaders(req *http.Request) {
 	for key, value := range conf.HeaderMap {
 		req.Header.Add(key, value)
 	}
+	if auth != nil {
+		// last, as signatures cover the headers before it
+		if err := auth.authenticate(req); err != nil {
+			log.Printf("could not authenticate %s %s, %v\n", req.Method, req.URL, err)
+			if conf.Crash {
+				log.Fatalf("halting.\n")
+			}
+		}
+	}
 }
 
 // Put does an ordinary REST (not ceph or s3) put operation.
-func (p RestProto) Put(path, size, oldRC string) {
+func (p RestProto) Put(path, size, oldRC string, o options) {
 	var bytes int64
 	var err error
 
@@ -151,34 +173,41 @@ func (p RestProto) Put(path, size, oldRC string) {
 		log.Fatalf("put size %q was unreadable, %v, halting\n",
 			size, err)
 	}
-	if bytes <= 0 {
-		fmt.Printf("%s 0 0 0 %s %s %d PUT\n",
-			time.Now().Format("2006-01-02 15:04:05.000"),
-			size, path, 411) // 411 means "length required"
+	if bytes < 0 {
+		// 411 means "length required"
+		reportPerformance(time.Now(), 0, 0, bytes, path, http.StatusLengthRequired, "PUT", oldRC, nil, "")
+		o.setRC(http.StatusLengthRequired)
 		return
 	}
-	// make sure we have a dummy file
-	fp, err := os.Open(junkDataFile)
-	if err != nil {
-		log.Fatalf("can't open data file %q, halting\n", junkDataFile)
+	if fault := o.chooseFault(); fault != "" {
+		body, _ := io.ReadAll(newContent(path, bytes)) // nolint, can't fail
+		p.misbehave(fault, "PUT", path, body, oldRC, o)
+		return
+	}
+	// an empty body has to be NoBody, or it's sent chunked, of unknown length
+	var content io.Reader = http.NoBody
+	if bytes > 0 {
+		content = newContent(path, bytes)
 	}
-	defer fp.Close() // nolint
 
 	initial := time.Now() // Response time starts
-	req, err := http.NewRequest("PUT", p.prefix+"/"+path, io.LimitReader(fp, bytes))
+	req, err := http.NewRequest("PUT", p.prefix+"/"+path, content)
 	if err != nil {
 		// report problem and exit
 		dumpXact(req, nil, nil, true, "error creating http request", err)
 		return
 	}
-	addHeaders(req)
+	req.ContentLength = bytes
+	addHeaders(req, o)
+	req, timing := withTiming(req)
 
-	resp, err := httpClient.Do(req)
+	resp, err := o.httpClient().Do(req)
 	if err != nil {
 		// Timeouts and bad parameters will trigger this case.
 		dumpXact(req, nil, nil, true, "error getting http response", err)
 	}
 	latency := time.Since(initial) // Response time ends
+	timing.setProto(resp.Proto)
 	contents, err := ioutil.ReadAll(resp.Body)
 	transferTime := time.Since(initial) - latency // Transfer time ends
 	defer resp.Body.Close()                       // nolint
@@ -192,14 +221,13 @@ func (p RestProto) Put(path, size, oldRC string) {
 	case conf.Verbose:
 		dumpXact(req, resp, contents, conf.Crash, "", nil)
 	}
-	//reportPerformance(initial, latency, transferTime, body, path, resp, oldRc)
-	fmt.Printf("%s %f %f 0 %s %s %d PUT\n",
-		initial.Format("2006-01-02 15:04:05.000"),
-		latency.Seconds(), transferTime.Seconds(), size, path, resp.StatusCode)
+	o.extract(path, resp.Header, contents)
+	reportPerformance(initial, latency, transferTime, bytes, path, resp.StatusCode, "PUT", oldRC, timing, "")
+	o.setRC(resp.StatusCode)
 }
 
 // Post does an ordinary REST (not ceph or s3) post operation.
-func (p RestProto) Post(path, size, oldRC, body string) {
+func (p RestProto) Post(path, size, oldRC, body string, o options) {
 	var err error
 
 	if conf.Debug {
@@ -210,6 +238,10 @@ func (p RestProto) Post(path, size, oldRC, body string) {
 	if body == "" {
 		log.Fatalf("load-testing POST requires a body field to be provided\n")
 	}
+	if fault := o.chooseFault(); fault != "" {
+		p.misbehave(fault, "POST", path, []byte(body), oldRC, o)
+		return
+	}
 	bodyReader := bytes.NewReader([]byte(body))
 
 	req, err := http.NewRequest("POST", p.prefix+"/"+strings.TrimPrefix(path, "/"), bodyReader)
@@ -218,17 +250,19 @@ func (p RestProto) Post(path, size, oldRC, body string) {
 		dumpXact(req, nil, nil, true, "error creating http request", err)
 		return
 	}
-	addHeaders(req)
+	addHeaders(req, o)
+	req, timing := withTiming(req)
 
 	log.Printf("\n-----\n%s\n-----\n", requestToString(req))
 	initial := time.Now() // Response time starts
-	resp, err := httpClient.Do(req)
+	resp, err := o.httpClient().Do(req)
 	if err != nil {
 		// Timeouts and bad parameters will trigger this case.
 		dumpXact(req, nil, nil, true, "error getting http response", err)
 		return
 	}
 	latency := time.Since(initial) // Response time ends
+	timing.setProto(resp.Proto)
 
 	contents, err := ioutil.ReadAll(resp.Body)
 	transferTime := time.Since(initial) - latency // Transfer time ends
@@ -247,10 +281,47 @@ func (p RestProto) Post(path, size, oldRC, body string) {
 	case conf.Verbose:
 		dumpXact(req, resp, contents, conf.Crash, "", nil)
 	}
-	//reportPerformance(initial, latency, transferTime, body, path, resp, oldRc)
-	fmt.Printf("%s %f %f 0 %d %s %d POST\n",
-		initial.Format("2006-01-02 15:04:05.000"),
-		latency.Seconds(), transferTime.Seconds(), len(body), path, resp.StatusCode)
+	o.extract(path, resp.Header, contents)
+	reportPerformance(initial, latency, transferTime, int64(len(body)), path, resp.StatusCode, "POST", oldRC,
+		timing, "")
+}
+
+// Delete does an ordinary REST DELETE
+func (p RestProto) Delete(path, oldRC string, o options) {
+	if conf.Debug {
+		log.Printf("in rest.Delete(%s)\n", path)
+	}
+	req, err := http.NewRequest("DELETE", p.prefix+"/"+path, nil)
+	if err != nil {
+		dumpXact(req, nil, nil, conf.Crash, "error creating http request", err)
+		return
+	}
+	addHeaders(req, o)
+	req, timing := withTiming(req)
+
+	initial := time.Now() // Response time starts
+	resp, err := o.httpClient().Do(req)
+	latency := time.Since(initial) // Response time ends
+	if err != nil {
+		dumpXact(req, nil, nil, conf.Crash, "error getting http response", err)
+		// 444 is nginx's code for server has returned no information and/or EOF
+		reportPerformance(initial, latency, 0, 0, path, 444, "DELETE", oldRC, timing, "")
+		return
+	}
+	timing.setProto(resp.Proto)
+	contents, err := io.ReadAll(resp.Body)
+	transferTime := time.Since(initial) - latency // Transfer time ends
+	defer resp.Body.Close()                       // nolint
+	if err != nil {
+		dumpXact(req, resp, contents, conf.Crash, "error reading http response", err)
+	}
+	switch {
+	case badDeleteCode(resp.StatusCode):
+		dumpXact(req, resp, contents, conf.Crash, "bad return code", nil)
+	case conf.Verbose:
+		dumpXact(req, resp, contents, conf.Crash, "", nil)
+	}
+	reportPerformance(initial, latency, transferTime, 0, path, resp.StatusCode, "DELETE", oldRC, timing, "")
 }
 
 // badGetCode is true if this isn't a 20X or 404
@@ -271,6 +342,15 @@ func badPutCode(i int) bool {
 	return true
 }
 
+// badDeleteCode is true if this isn't a 20X, or a 404 for something
+// that's already gone
+func badDeleteCode(i int) bool {
+	if i == 200 || i == 202 || i == 204 || i == 404 {
+		return false
+	}
+	return true
+}
+
 // dumpXact dumps request and response together to stderr, with a reason
 func dumpXact(req *http.Request, resp *http.Response, body []byte, crash bool, reason string, err error) {
 	var r string
@@ -4,7 +4,6 @@ package loadtesting
 // input looks like "01-Mar-2017 16:00:00 0 0 0 0 path 404 GET"
 
 import (
-	"encoding/csv"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +11,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -26,6 +26,8 @@ const (
 	S3Protocol         // Amazon s3 protocol or compatable
 	CephProtocol       // reserved for native ceph protocol
 	TimeBudgetProtocol // see if we're inside our time budget
+	GRPCProtocol       // unary gRPC calls
+	WebSocketProtocol  // websocket conversations
 
 	TerminationTimeout = 10 // seconds to wait after "done" signal
 )
@@ -33,9 +35,15 @@ const (
 // operations are the things a protocol must support
 type operation interface {
 	Init()
-	Get(path, oldRc string)
-	Put(path, size, oldRc string)
-	Post(path, size, oldRc, body string)
+	Get(path, oldRc string, o options)
+	Put(path, size, oldRc string, o options)
+	Post(path, size, oldRc, body string, o options)
+}
+
+// deleteOperation is what a protocol that can delete does, in addition
+// to operation
+type deleteOperation interface {
+	Delete(path, oldRc string, o options)
 }
 
 // These are the field names in the csv file
@@ -54,26 +62,87 @@ const ( // nolint
 
 // Config contains all the optional parameters.
 type Config struct {
-	Verbose      bool   // Extra info about requests
-	Debug        bool   // Extra info about program
-	Zero         bool   // Have mkLoadTestFiles create zero-size files
-	Crash        bool   // Halt on any error
-	Serialize    bool   // FIXME semi-evil hack
-	Cache        bool   // allow caching
-	Tail         bool   // tail a log
-	Rewind       bool   // rewind at EOF and keep running
-	AkamaiDebug  bool   // add Akamai debug headers
-	Protocol     int    // rest, etc
-	S3Bucket     string // s3-specific options
-	S3Key        string
-	S3Secret     string
-	Strip        string
-	StepDuration int               // duration of a test step
-	HostHeader   string            // add a Host: header
-	HeaderMap    map[string]string // one or more key:value headers
-	R            bool              // read tests allowed
-	W            bool              // write tests allowed
-	BufSize      int64             // max size of written file
+	Verbose         bool   // Extra info about requests
+	Debug           bool   // Extra info about program
+	Zero            bool   // Have mkLoadTestFiles create zero-size files
+	Crash           bool   // Halt on any error
+	Serialize       bool   // FIXME semi-evil hack
+	Cache           bool   // allow caching
+	Tail            bool   // tail a log
+	Rewind          bool   // rewind at EOF and keep running
+	AkamaiDebug     bool   // add Akamai debug headers
+	Protocol        int    // rest, etc
+	S3Bucket        string // s3-specific options
+	S3Key           string
+	S3Secret        string
+	Strip           string
+	StepDuration    int               // duration of a test step
+	HostHeader      string            // add a Host: header
+	HeaderMap       map[string]string // one or more key:value headers
+	R               bool              // read tests allowed
+	W               bool              // write tests allowed
+	BufSize         int64             // max size of written file
+	Format          string            // input format, perf, har, jtl, alb, cloudfront or s3log
+	PerLabel        bool              // aggregate each path separately
+	RecordBodies    bool              // have the recorder keep request bodies
+	RecordHeaders   []string          // and these request headers
+	Template        bool              // expand ${name} in paths, bodies and headers
+	Vars            string            // name=value pairs for templates
+	DataFile        string            // csv file of values for templates
+	Auth            string            // basic, bearer, oauth2 or hmac
+	AuthUser        string            // user, client id or key id
+	AuthSecret      string            // password, token or secret, or env:NAME or file:path
+	AuthTokenURL    string            // where oauth2 gets tokens
+	AuthScopes      string            // and the scopes it asks for
+	TLSCA           string            // CA bundle to trust, in pem
+	TLSCert         string            // client certificate, in pem
+	TLSKey          string            // and its key
+	TLSServerName   string            // override the SNI and verified name
+	TLSMinVersion   string            // 1.0, 1.1, 1.2 or 1.3
+	TLSCiphers      string            // comma-separated cipher suites allowed
+	TLSInsecure     bool              // skip verifying the server's certificate
+	Trace           bool              // report dns, connect, tls and ttfb times
+	NoKeepAlive     bool              // a new connection for every request
+	MaxConnsPerHost int               // limit on connections per host, 0 for none
+	MaxIdleConns    int               // idle connections kept per host
+	IdleTimeout     time.Duration     // how long to keep them
+	Timeout         time.Duration     // per-request timeout, 0 for none
+	DialTimeout     time.Duration     // connect timeout
+	ClientPerWorker bool              // each worker is a distinct client, with its own connections
+	HTTPVersion     string            // 1.1, auto, 2 or h2c
+	H2Streams       int               // concurrent streams per HTTP/2 connection, 0 for the server's limit
+	Faults          string            // client faults to commit, kind:rate;...
+	FaultReadRate   int               // bytes/second for slow-read, 0 for 1024
+	FaultHeaderSize int               // bytes of header for big-header, 0 for 64KiB
+	GRPCDescriptors string            // descriptor set for grpc, or "" to use server reflection
+	FSDirect        bool              // bypass the page cache with O_DIRECT
+	FSSync          bool              // fsync after each write
+	FSBufferSize    int               // bytes per read, 0 for 128KiB
+	SimLatency      string            // simulator service times, pattern=distribution;...
+	SimSizes        string            // perf file to take response sizes from
+	SimErrors       string            // return codes to inject, rc:rate;...
+	SimConcurrency  int               // requests served at once, 0 for any number
+	SimQueue        int               // requests allowed to wait for a slot, 0 for any number
+	SimModel        string            // M/M/c or M/D/c, to be a queue of known capacity
+	SimServers      int               // the model's c
+	SimService      time.Duration     // the model's mean service time
+	SimS3           bool              // be a small S3
+	SimBuckets      string            // and have these comma-separated buckets
+	Content         string            // random or compressible, for what we write
+	Verify          bool              // check that what we GET is what was written
+	MkWorkers       int               // files mkLoadTestFiles creates at once, 0 for 1
+	MkRate          int               // and per second, 0 for no limit
+	MkManifest      string            // where it lists what it's created, "" for nowhere
+	MkResume        bool              // and skips what the manifest lists
+	TeardownList    bool              // teardown reads a list of paths, not a perf file
+	TeardownWorkers int               // files teardown deletes at once, 0 for 1
+	TeardownRate    int               // and per second, 0 for no limit
+	DryRun          bool              // have teardown only say what it would delete
+	SynthSeed       int64             // seed for synthesize, 0 for the model's
+	SynthScale      float64           // multiply the model's rates by this, 0 for 1
+	SynthLonger     float64           // and its durations by this, 0 for 1
+	FitStep         time.Duration     // characterize's rate steps, 0 for a minute
+	FitPaths        string            // and its model's paths, "" for /synthetic/%d
 }
 
 // ExpectedRate for this part of the test, in TPS/requests per second.
@@ -83,20 +152,48 @@ var conf Config
 var op operation
 var random = rand.New(rand.NewSource(42))
 var pipe = make(chan []string, 100)
-var shutdown chan bool // visible in whole file, initialed and shutdown in generateLoad
-var junkDataFile = "/tmp/LoadTestJunkDataFile"
+var shutdown chan bool      // visible in whole file, initialed and shutdown in generateLoad
+var workers sync.WaitGroup  // the running workers, waited for by generateLoad
+var requests sync.WaitGroup // the requests they started, likewise
+var scratchDir string       // this run's own temporary files, "" for the system's
 
 const size = 396759652 // nolint // FIXME, this is a heuristic
 
-// RunLoadTest does whatever main figured out that the caller wanted.
+// cleanupTime is how long to wait for the last requests, after the load ends
+var cleanupTime = time.Duration(TerminationTimeout) * time.Second
+
+// RunLoadTest does whatever main figured out that the caller wanted, then exits.
 func RunLoadTest(f *os.File, filename string, fromTime, forTime int,
+	tpsTarget, progressRate, startTps int, baseURL string, cfg Config) {
+	runLoadTest(f, filename, fromTime, forTime, tpsTarget, progressRate, startTps, baseURL, cfg)
+	os.Exit(0)
+}
+
+// runLoadTest runs a test, returning when it's done and the workers have stopped
+func runLoadTest(f *os.File, filename string, fromTime, forTime int,
 	tpsTarget, progressRate, startTps int, baseURL string, cfg Config) {
 	//var processed = 0
 	conf = cfg // Required, also makes it show in the debugger
 	defer reportRUsage("RunLoadTest", time.Now())
 
+	// Set up the http client and authentication, before Init uses them
+	var err error
+	httpClient = mustMakeHTTPClient(conf)
+	auth, err = newAuthenticator(conf)
+	if err != nil {
+		log.Fatalf("Fatal error setting up %s authentication: %s, halting\n", conf.Auth, err)
+	}
+
+	faults, err = parseFaults(conf.Faults)
+	if err != nil {
+		log.Fatalf("Fatal error in --faults: %s, halting\n", err)
+	}
+
 	// Figure out which set of operations to use
 	switch conf.Protocol {
+	case FilesystemProtocol:
+		op = FilesystemProto{root: baseURL}
+		op.Init()
 	case RESTProtocol:
 		op = RestProto{prefix: baseURL}
 		op.Init()
@@ -106,38 +203,71 @@ func RunLoadTest(f *os.File, filename string, fromTime, forTime int,
 	case TimeBudgetProtocol:
 		op = timeBudgetProto{prefix: baseURL}
 		op.Init()
+	case GRPCProtocol:
+		op = GRPCProto{prefix: baseURL}
+		op.Init()
+	case WebSocketProtocol:
+		op = WebSocketProto{prefix: baseURL}
+		op.Init()
 	default:
 		log.Fatalf("protocol %d not implemented yet", conf.Protocol)
 	}
 	log.Printf("Starting test from %d requests/second to %d by %d", startTps, tpsTarget, progressRate)
 
-	// Create data for rw and wo tests
-	if conf.BufSize > 0 {
-		log.Printf("Creating %d-byte data file %q\n", conf.BufSize,
-			junkDataFile)
-		mustCreateFilesystemFile(junkDataFile, conf.BufSize)
-		defer os.Remove(junkDataFile) // nolint
-	} else if conf.BufSize < 0 {
+	// Set up the run's variables, and templating, if asked for
+	templates, err = newTemplater(conf.Vars, conf.DataFile)
+	if err != nil {
+		log.Fatalf("Fatal error setting up templates: %s, halting\n", err)
+	}
+	expanding.Store(conf.Template || conf.Vars != "" || conf.DataFile != "")
+	if expanding.Load() {
+		log.Printf("Templating with run id %s\n", templates.runID)
+	}
+
+	// A directory of our own, so concurrent runs don't share temporary files
+	scratchDir, err = os.MkdirTemp("", "runLoadTest-")
+	if err != nil {
+		log.Fatalf("Fatal error making a scratch directory: %s, halting\n", err)
+	}
+	defer func() {
+		os.RemoveAll(scratchDir) // nolint
+		scratchDir = ""
+	}()
+
+	// Data for rw and wo tests is made as it's written, see content.go
+	if conf.BufSize < 0 {
 		log.Fatalf("A negative size for data files (%d) is meaningless, halting\n", conf.BufSize)
-	} // else it's a zero-size file'
+	}
+	if err = checkContent(conf.Content); err != nil {
+		log.Fatalf("Fatal error in --content: %s, halting\n", err)
+	}
 
 	// select some work to do from the input file
-	go workSelector(f, filename, fromTime, forTime, pipe)
+	pipe = make(chan []string, 100)
+	done, selected := make(chan bool), make(chan bool)
+	go func() {
+		workSelector(f, filename, fromTime, forTime, pipe, done)
+		close(selected)
+	}()
 	// which pipes work to ...
 	generateLoad(pipe, tpsTarget, progressRate, startTps, baseURL)
 
-	//log.Printf("RunLoadTest, waiting for shutdown\n")
-	<-shutdown
-	os.Exit(0)
+	// stop the selector too, so nothing is left running when we return
+	close(done)
+	<-selected
 }
 
-// workSelector pipes a selection from a file to the workers
-func workSelector(f *os.File, filename string, startFrom, runFor int, pipe chan []string) { // nolint
+// workSelector pipes a selection from a file to the workers, until it's
+// done or done is closed
+func workSelector(f *os.File, filename string, startFrom, runFor int, pipe chan []string, done chan bool) { // nolint
 	var watcher *fsnotify.Watcher
 
 	if conf.Debug {
 		log.Printf("in workSelector(r, %s, startFrom=%d runFor=%d, pipe)\n", filename, startFrom, runFor)
 	}
+	if conf.Tail && conf.Format != "" && conf.Format != "perf" {
+		log.Fatalf("Only perf files can be tailed, not %s files, halting\n", conf.Format)
+	}
 	if conf.Tail {
 		// if we're tailing, start at the end
 		_, err := f.Seek(0, io.SeekEnd)
@@ -158,21 +288,33 @@ func workSelector(f *os.File, filename string, startFrom, runFor int, pipe chan
 			filename)
 	}
 
-	r := csv.NewReader(f)
-	r.Comma = ' '
-	r.Comment = '#'
-	r.FieldsPerRecord = -1 // ignore differences
-
+	r := mustMakeReader(f, filename)
 	skipForward(startFrom, r, filename)
-	_ = copyToPipe(runFor, r, f, filename, pipe, watcher)
+	_ = copyToPipe(runFor, r, f, filename, pipe, watcher, done)
 	//log.Printf("Input reader loaded %d records\n", recNo)
 }
 
-// copyToPipe pipes work to the workers. Returns number of lines read.
-func copyToPipe(runFor int, r *csv.Reader, f *os.File, filename string, pipe chan []string, watcher *fsnotify.Watcher) int {
+// mustMakeReader makes a record reader for the configured input format
+func mustMakeReader(f *os.File, filename string) recordReader {
+	r, err := newRecordReader(conf.Format, f)
+	if err != nil {
+		log.Fatalf("Fatal error reading %s: %s\n", filename, err)
+	}
+	return r
+}
+
+// copyToPipe pipes work to the workers, until done is closed. Returns
+// number of lines read.
+func copyToPipe(runFor int, r recordReader, f *os.File, filename string, pipe chan []string,
+	watcher *fsnotify.Watcher, done chan bool) int {
 	recNo := 0
 forloop:
 	for ; recNo < runFor; recNo++ {
+		select {
+		case <-done:
+			break forloop
+		default:
+		}
 		record, err := r.Read()
 		//log.Printf("copyToPipe read %d, %q, err = %v\n", recNo, record, err)
 
@@ -180,13 +322,15 @@ forloop:
 		case err == io.EOF && conf.Rewind:
 			log.Printf("At EOF, rereading from the beginning\n")
 			f.Seek(0, io.SeekStart)
+			r = mustMakeReader(f, filename)
+			continue // an empty record would stop the worker that got it
 		case err == io.EOF && conf.Tail:
 			// just keep reading, even if we truncate...
 			if watcher == nil {
 				time.Sleep(100 * time.Millisecond)
 			} else {
 				log.Print("waiting for fsnotify\n")
-				if err = waitForChange(watcher); err != nil {
+				if err = waitForChange(watcher, done); err != nil {
 					log.Fatalf("Fatal error waiting for fsnotify on %s, %v\n", filename, err)
 				}
 			}
@@ -210,7 +354,11 @@ forloop:
 		}
 
 		//log.Printf("copyToPipe copied in %qn", record)
-		pipe <- record
+		select {
+		case pipe <- record:
+		case <-done:
+			break forloop
+		}
 	}
 
 	return recNo
@@ -234,11 +382,21 @@ func generateLoad(pipe chan []string, tpsTarget, progressRate, startTps int, url
 		log.Fatalf("neither steady nor progressive load specified, halting\n")
 	}
 	// each of the above should return when done, and then I can shut down.
-	close(shutdown) // FIXME this should quietly shut everything off
+	close(shutdown)
+	workers.Wait()
 
 	// the old heuristic is 35 seconds because the pipe contents can be large
-	log.Printf("Closing down, starting %d sec cleanup timer\n", TerminationTimeout)
-	time.Sleep(time.Duration(float64(TerminationTimeout) * float64(time.Second)))
+	log.Printf("Closing down, waiting up to %s for the last requests\n", cleanupTime)
+	finished := make(chan bool)
+	go func() {
+		requests.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(cleanupTime):
+		log.Printf("Some requests were still running after %s, not waiting for them\n", cleanupTime)
+	}
 	log.Printf("Complete.\n")
 }
 
@@ -248,6 +406,7 @@ func runSteadyLoad(tpsTarget int, pipe chan []string) {
 	log.Printf("starting runSteadyLoad, at %d requests/second\n", tpsTarget)
 	// start tpsTarget worth of workers
 	for i := 0; i < tpsTarget; i++ {
+		workers.Add(1)
 		go worker(pipe)
 	}
 	// run until pipe is empty
@@ -274,6 +433,7 @@ func runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps int, pipe
 	rate := startTps
 	ExpectedRate = startTps
 	for i := 0; i < startTps; i++ {
+		workers.Add(1)
 		go worker(pipe)
 	}
 	// add to the workers until we have enough
@@ -286,6 +446,7 @@ func runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps int, pipe
 			break // OK, we're past the range, quit.
 		}
 		for i := 0; i < progressRate; i++ {
+			workers.Add(1)
 			go worker(pipe)
 		}
 		log.Printf("now at %d requests/second\n", rate)
@@ -293,36 +454,47 @@ func runProgressivelyIncreasingLoad(progressRate, tpsTarget, startTps int, pipe
 	}
 }
 
-// worker reads and executes a task every second until it hits eof.
-// run as a goroutine
+// worker reads and executes a task every second until it hits eof or
+// shutdown. run as a goroutine, after adding it to workers
 func worker(pipe chan []string) {
+	defer workers.Done()
+	w := newWorkerState()
+	if conf.ClientPerWorker {
+		// a virtual user, with connections of its own
+		w.client = mustMakeHTTPClient(conf)
+	}
 	if conf.Protocol == TimeBudgetProtocol {
 		//log.Print("worker got TimeBudgetProtocol\n")
 		// Do the operation immediately, once, to measure its speed
-		_ = doOneOperation()
+		_ = doOneOperation(w)
 		return
 	}
 	// wait a random fraction of one second before starting tpo loop, for randomness.
-	time.Sleep(time.Duration(random.Float64() * float64(time.Second)))
+	select {
+	case <-time.After(time.Duration(random.Float64() * float64(time.Second))):
+	case <-shutdown:
+		return
+	}
 
-	for range time.Tick(1 * time.Second) { // nolint
-		eof := doOneOperation()
-		if eof == true {
-			//log.Print("worker: returned on eof from doOneOperation, exited.\n")
-			return // exit goroutine
-		}
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
 		select {
+		case <-ticker.C:
 		case <-shutdown:
 			//log.Print("worker: shutdown signalled, no more requests to process, exited.\n")
 			return // exit goroutine
-		default:
-			//log.Printf("worker: no signal yet\n")
+		}
+		eof := doOneOperation(w)
+		if eof == true {
+			//log.Print("worker: returned on eof from doOneOperation, exited.\n")
+			return // exit goroutine
 		}
 	}
 }
 
 // doOneOperation gets one unit of work and carries it out. Returns true at EOF
-func doOneOperation() bool {
+func doOneOperation(w *workerState) bool {
 	var r []string
 
 	r, eof := getWork()
@@ -340,19 +512,117 @@ func doOneOperation() bool {
 	case len(r) < 9:
 		// bad input data, crash
 		log.Fatalf("number of fields < 9 in %v", r)
-	case r[operatorField] == "GET" && conf.R:
-		go op.Get(r[pathField], r[returnCodeField])
-	case r[operatorField] == "PUT" && conf.W:
-		go op.Put(r[pathField], r[bytesField], r[returnCodeField])
-	case r[operatorField] == "POST" && conf.R:
-		go op.Post(r[pathField], r[bytesField], r[returnCodeField], r[bodyField])
-	//case r[operatorField] == "DELE":
-	//	go op.Dele(r[pathField], r[bytesField], r[returnCodeField]) // nolint
-	//case r[operatorField] == "HEAD":
-	//	go op.Head(r[pathField], r[bytesField], r[returnCodeField]) // nolint
-	default:
+	case !willDo(r[operatorField]):
 		log.Printf("read = %v, write = %v operation %q in %v invalid, ignored\n",
 			conf.R, conf.W, r[operatorField], r)
+		return false
+	}
+
+	o := recordOptions(r)
+	o.client = w.client
+	if len(o.extracts) > 0 && expanding.CompareAndSwap(false, true) {
+		// what it captures is for later records to use
+		log.Printf("Records extract values, so ${name} is expanded from now on, with run id %s\n",
+			templates.runID)
+	}
+	switch {
+	case o.session != "":
+		// a session's records run in order, each after the last has
+		// finished and stored whatever it extracted
+		s := sessionNamed(o.session)
+		requests.Add(1)
+		s.run(func() {
+			defer requests.Done()
+			r := r
+			if expanding.Load() {
+				r = templates.expandRecord(r, w, s)
+			}
+			o := recordOptions(r)
+			o.client = w.client
+			o.store = s
+			perform(r, o)
+		})
+	case expanding.Load():
+		r = templates.expandRecord(r, w, nil)
+		o = recordOptions(r)
+		o.client = w.client
+		o.store = templates
+		requests.Add(1)
+		go performCounted(r, o)
+	default:
+		o.store = templates
+		requests.Add(1)
+		go performCounted(r, o)
+	}
+	return false
+}
+
+// performCounted performs a record, then counts it as finished
+func performCounted(r []string, o options) {
+	defer requests.Done()
+	perform(r, o)
+}
+
+// perform carries out one record's operation
+func perform(r []string, o options) {
+	switch r[operatorField] {
+	case "GET":
+		op.Get(r[pathField], r[returnCodeField], o)
+	case "PUT":
+		op.Put(r[pathField], r[bytesField], r[returnCodeField], o)
+	case "POST":
+		op.Post(r[pathField], r[bytesField], r[returnCodeField], r[bodyField], o)
+	case "DELETE", "DELE":
+		d, ok := op.(deleteOperation)
+		if !ok {
+			log.Printf("operation %q is not implemented by this protocol, ignored\n", r[operatorField])
+			return
+		}
+		d.Delete(r[pathField], r[returnCodeField], o)
+	case "OPEN", "SEND", "EXPECT", "CLOSE":
+		performMessage(r, o)
+		//case "HEAD":
+		//	op.Head(r[pathField], r[bytesField], r[returnCodeField]) // nolint
+	}
+}
+
+// performMessage carries out a message operation, if the protocol has them
+func performMessage(r []string, o options) {
+	m, ok := op.(messageOperation)
+	if !ok {
+		log.Printf("operation %q needs a message protocol, like --websocket, ignored\n",
+			r[operatorField])
+		return
+	}
+	if o.session == "" {
+		// concurrent OPENs of a path would replace each other's connections
+		log.Printf("operation %q needs a session=name option, ignored\n", r[operatorField])
+		return
+	}
+	var body string
+	if len(r) > bodyField {
+		body = r[bodyField]
+	}
+	switch r[operatorField] {
+	case "OPEN":
+		m.Open(r[pathField], r[returnCodeField], o)
+	case "SEND":
+		m.Send(r[pathField], body, r[returnCodeField], o)
+	case "EXPECT":
+		m.Expect(r[pathField], body, r[returnCodeField], o)
+	case "CLOSE":
+		m.Close(r[pathField], r[returnCodeField], o)
+	}
+}
+
+// willDo is true if doOneOperation will carry out operator in the
+// current read/write mode. Anything else is logged and ignored.
+func willDo(operator string) bool {
+	switch operator {
+	case "GET", "POST", "OPEN", "SEND", "EXPECT", "CLOSE":
+		return conf.R
+	case "PUT", "DELETE", "DELE":
+		return conf.W
 	}
 	return false
 }
@@ -386,9 +656,11 @@ func getWork() ([]string, bool) {
 
 // waitForChange waits for the tail of a file to be written to
 // cargo courtesy Satyajit Ranjeev, http://satran.in/2017/11/15/Implementing_tails_follow_in_go.html
-func waitForChange(w *fsnotify.Watcher) error {
+func waitForChange(w *fsnotify.Watcher, done chan bool) error {
 	for {
 		select {
+		case <-done:
+			return nil
 		case event := <-w.Events:
 			if event.Op&fsnotify.Write == fsnotify.Write {
 				return nil
@@ -399,10 +671,11 @@ func waitForChange(w *fsnotify.Watcher) error {
 	}
 }
 
-// reportPerformance in standard format
+// reportPerformance in standard format, for every protocol and operator,
+// with the rate we were offering and any annotations after it
 func reportPerformance(initial time.Time, latency time.Duration,
-	transferTime time.Duration, body []byte, path string,
-	rc int, oldRc string) {
+	transferTime time.Duration, size int64, path string,
+	rc int, operator, oldRc string, timing *requestTiming, extra string) {
 	var annotation = ""
 
 	if oldRc != "" {
@@ -411,10 +684,10 @@ func reportPerformance(initial time.Time, latency time.Duration,
 			annotation = fmt.Sprintf(" expectedRC=%s", oldRc)
 		}
 	}
-	fmt.Printf("%s %f %f 0 %d %s %d GET %d %s\n",
+	fmt.Printf("%s %f %f 0 %d %s %d %s %d%s%s%s\n",
 		initial.Format("2006-01-02 15:04:05.000"),
-		latency.Seconds(), transferTime.Seconds(), len(body), path,
-		rc, ExpectedRate, annotation)
+		latency.Seconds(), transferTime.Seconds(), size, path,
+		rc, operator, ExpectedRate, annotation, timing.annotation(), extra)
 }
 
 // reportRusage reports cpu-seconds, memory and IOPS used
@@ -0,0 +1,457 @@
+package loadtesting
+
+// s3Server is a minimal S3-compatible stand-in, so S3 tests can be
+// rehearsed on a laptop and S3Proto can be tested. It speaks path-style
+// requests, /bucket/key, and does GET, HEAD, PUT, DELETE, listing and
+// multipart uploads, holding everything in memory. Signatures are
+// accepted without being checked, so any key and secret will do.
+// Latency and errors are added by running it inside the simulator.
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Server satisfies http.Handler by being a small S3
+type s3Server struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]*s3Object // bucket to key to object
+	uploads map[string]*s3Upload            // multipart uploads, by id
+	nextID  int
+}
+
+// s3Object is a stored object
+type s3Object struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+// s3Upload is a multipart upload in progress
+type s3Upload struct {
+	bucket, key string
+	parts       map[int][]byte
+}
+
+// These are the XML documents S3 exchanges
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+type s3Contents struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+type s3ListResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Marker                string `xml:",omitempty"`
+	NextMarker            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	KeyCount              int    `xml:",omitempty"`
+	MaxKeys               int
+	IsTruncated           bool
+	Contents              []s3Contents
+}
+
+type s3BucketList struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   struct{ ID, DisplayName string }
+	Buckets struct {
+		Bucket []struct{ Name, CreationDate string }
+	}
+}
+
+type s3InitiateResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompleteRequest struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type s3CompleteResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+// newS3Server makes a server with the named buckets already created
+func newS3Server(buckets ...string) *s3Server {
+	s := &s3Server{
+		buckets: make(map[string]map[string]*s3Object),
+		uploads: make(map[string]*s3Upload),
+	}
+	for _, b := range buckets {
+		if b = strings.TrimSpace(b); b != "" {
+			s.buckets[b] = make(map[string]*s3Object)
+		}
+	}
+	return s
+}
+
+// ServeHTTP dispatches on the method, the path and the query
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	q := r.URL.Query()
+	switch {
+	case bucket == "" && r.Method == "GET":
+		s.listBuckets(w)
+	case bucket == "":
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "not a bucket operation", r.URL.Path)
+	case key == "" && r.Method == "PUT":
+		s.createBucket(w, bucket)
+	case key == "" && r.Method == "DELETE":
+		s.deleteBucket(w, bucket)
+	case key == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		s.listObjects(w, r, bucket)
+	case key == "":
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "not a bucket operation", r.URL.Path)
+	case r.Method == "POST" && q.Has("uploads"):
+		s.initiateUpload(w, bucket, key)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		s.uploadPart(w, r, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		s.completeUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		s.abortUpload(w, q.Get("uploadId"))
+	case r.Method == "PUT":
+		s.putObject(w, r, bucket, key)
+	case r.Method == "GET" || r.Method == "HEAD":
+		s.getObject(w, r, bucket, key)
+	case r.Method == "DELETE":
+		s.deleteObject(w, bucket, key)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported method", r.URL.Path)
+	}
+}
+
+// listBuckets lists all the buckets
+func (s *s3Server) listBuckets(w http.ResponseWriter) {
+	var result s3BucketList
+
+	s.mu.Lock()
+	for name := range s.buckets {
+		result.Buckets.Bucket = append(result.Buckets.Bucket,
+			struct{ Name, CreationDate string }{name, time.Now().UTC().Format(time.RFC3339)})
+	}
+	s.mu.Unlock()
+	sort.Slice(result.Buckets.Bucket, func(i, j int) bool {
+		return result.Buckets.Bucket[i].Name < result.Buckets.Bucket[j].Name
+	})
+	writeXML(w, http.StatusOK, result)
+}
+
+// createBucket makes a bucket, which is harmless if it exists
+func (s *s3Server) createBucket(w http.ResponseWriter, bucket string) {
+	s.mu.Lock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*s3Object)
+	}
+	s.mu.Unlock()
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBucket removes an empty bucket
+func (s *s3Server) deleteBucket(w http.ResponseWriter, bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	switch {
+	case !ok:
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+	case len(objects) > 0:
+		writeS3Error(w, http.StatusConflict, "BucketNotEmpty", "the bucket is not empty", "/"+bucket)
+	default:
+		delete(s.buckets, bucket)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listObjects lists keys in order, by either version of the api
+func (s *s3Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	maxKeys := 1000
+	if n, err := strconv.Atoi(q.Get("max-keys")); err == nil && n >= 0 && n < maxKeys {
+		maxKeys = n
+	}
+	v2 := q.Get("list-type") == "2"
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("continuation-token")
+		if after == "" {
+			after = q.Get("start-after")
+		}
+	}
+
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.mu.Unlock()
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+		return
+	}
+	var keys []string
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := s3ListResult{Name: bucket, Prefix: prefix, MaxKeys: maxKeys}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+	}
+	for _, key := range keys {
+		o := objects[key]
+		result.Contents = append(result.Contents, s3Contents{
+			Key:          key,
+			LastModified: o.modified.UTC().Format(time.RFC3339),
+			ETag:         o.etag,
+			Size:         len(o.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	s.mu.Unlock()
+
+	if v2 {
+		result.KeyCount = len(keys)
+		result.ContinuationToken = q.Get("continuation-token")
+		if result.IsTruncated {
+			result.NextContinuationToken = keys[len(keys)-1]
+		}
+	} else {
+		result.Marker = q.Get("marker")
+		if result.IsTruncated {
+			result.NextMarker = keys[len(keys)-1]
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+}
+
+// putObject stores an object, replacing any with the same key
+func (s *s3Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error(), r.URL.Path)
+		return
+	}
+	if !s.store(bucket, key, data, etagOf(data)) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+		return
+	}
+	w.Header().Set("ETag", etagOf(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+// store saves an object, returning false if there's no such bucket
+func (s *s3Server) store(bucket, key string, data []byte, etag string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		return false
+	}
+	objects[key] = &s3Object{data: data, etag: etag, modified: time.Now()}
+	return true
+}
+
+// getObject serves an object, with ranges, for the downloader
+func (s *s3Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	var o *s3Object
+	if ok {
+		o = objects[key]
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+	case o == nil:
+		writeS3Error(w, http.StatusNotFound, "NoSuchKey", "the key does not exist", r.URL.Path)
+	default:
+		w.Header().Set("ETag", o.etag)
+		w.Header().Set("Content-Type", "binary/octet-stream")
+		w.Header().Set("Accept-Ranges", "bytes")
+		http.ServeContent(w, r, "", o.modified, bytes.NewReader(o.data))
+	}
+}
+
+// deleteObject removes an object. As in S3, it's not an error if it's absent.
+func (s *s3Server) deleteObject(w http.ResponseWriter, bucket, key string) {
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	if ok {
+		delete(objects, key)
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// initiateUpload starts a multipart upload
+func (s *s3Server) initiateUpload(w http.ResponseWriter, bucket, key string) {
+	s.mu.Lock()
+	_, ok := s.buckets[bucket]
+	s.nextID++
+	id := fmt.Sprintf("upload-%d", s.nextID)
+	if ok {
+		s.uploads[id] = &s3Upload{bucket: bucket, key: key, parts: make(map[int][]byte)}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+		return
+	}
+	writeXML(w, http.StatusOK, s3InitiateResult{Bucket: bucket, Key: key, UploadID: id})
+}
+
+// uploadPart stores one part of an upload
+func (s *s3Server) uploadPart(w http.ResponseWriter, r *http.Request, id, partNumber string) {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > 10000 {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument", "bad part number", r.URL.Path)
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeS3Error(w, http.StatusBadRequest, "IncompleteBody", err.Error(), r.URL.Path)
+		return
+	}
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if ok {
+		u.parts[n] = data
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist", r.URL.Path)
+		return
+	}
+	w.Header().Set("ETag", etagOf(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeUpload joins the parts into an object. Its ETag is, as in S3,
+// the md5 of the parts' md5s, and the number of parts.
+func (s *s3Server) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	var req s3CompleteRequest
+
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeS3Error(w, http.StatusBadRequest, "MalformedXML", err.Error(), r.URL.Path)
+		return
+	}
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if ok {
+		delete(s.uploads, id)
+	}
+	s.mu.Unlock()
+	if !ok || u.bucket != bucket || u.key != key {
+		writeS3Error(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist", r.URL.Path)
+		return
+	}
+
+	var data []byte
+	sums := md5.New()
+	for i, part := range req.Parts {
+		p, ok := u.parts[part.PartNumber]
+		if !ok || (i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber) ||
+			strings.Trim(part.ETag, `"`) != strings.Trim(etagOf(p), `"`) {
+			writeS3Error(w, http.StatusBadRequest, "InvalidPart",
+				fmt.Sprintf("part %d is missing, out of order or changed", part.PartNumber), r.URL.Path)
+			return
+		}
+		data = append(data, p...)
+		sum := md5.Sum(p)
+		sums.Write(sum[:]) // nolint
+	}
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sums.Sum(nil)), len(req.Parts))
+	if !s.store(bucket, key, data, etag) {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist", "/"+bucket)
+		return
+	}
+	writeXML(w, http.StatusOK, s3CompleteResult{Location: "/" + bucket + "/" + key,
+		Bucket: bucket, Key: key, ETag: etag})
+}
+
+// abortUpload discards an upload
+func (s *s3Server) abortUpload(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// etagOf is the quoted md5 of data, as S3 makes for a simple PUT
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// writeXML sends a document
+func writeXML(w http.ResponseWriter, rc int, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(rc)
+	w.Write([]byte(xml.Header)) // nolint
+	w.Write(body)               // nolint
+}
+
+// writeS3Error sends an error document
+func writeS3Error(w http.ResponseWriter, rc int, code, message, resource string) {
+	writeXML(w, rc, s3Error{Code: code, Message: message, Resource: resource})
+}
+
+// s3ErrorCode is the S3 code for an http return code, for injected errors
+func s3ErrorCode(rc int) string {
+	switch rc {
+	case http.StatusForbidden:
+		return "AccessDenied"
+	case http.StatusNotFound:
+		return "NoSuchKey"
+	case http.StatusServiceUnavailable:
+		return "SlowDown"
+	case http.StatusInternalServerError:
+		return "InternalError"
+	}
+	return http.StatusText(rc)
+}
@@ -0,0 +1,120 @@
+package loadtesting
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// withS3 runs f with S3Proto set up against a simulator that's an S3
+// with a bucket called "test"
+func withS3(t *testing.T, sim Config, f func(url string)) {
+	sim.SimS3 = true
+	sim.SimBuckets = "test"
+	s, err := newSimulator(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	savedConf, savedOp, savedSvc, savedRate := conf, op, svc, ExpectedRate
+	defer func() {
+		conf, op, svc, ExpectedRate = savedConf, savedOp, savedSvc, savedRate
+	}()
+	ExpectedRate = 0
+	conf = Config{R: true, W: true, S3Key: "key", S3Secret: "secret", S3Bucket: "test"}
+	svc = nil
+	op = S3Proto{prefix: server.URL}
+	op.Init()
+	f(server.URL)
+}
+
+// TestS3Proto puts and gets objects, small ones and ones big enough to
+// need multipart uploads and ranged downloads
+func TestS3Proto(t *testing.T) {
+	withS3(t, Config{}, func(url string) {
+		tests := []struct {
+			record []string
+			want   string
+		}{
+			{[]string{"PUT", "small", "1000"}, " 1000 small 201 PUT"},
+			{[]string{"GET", "small", "0"}, " 1000 small 200 GET"},
+			{[]string{"PUT", "dir/big", "6000000"}, " 6000000 dir/big 201 PUT"},
+			{[]string{"GET", "dir/big", "0"}, " 6000000 dir/big 200 GET"},
+			{[]string{"GET", "missing", "0"}, " missing 404 GET 0 expectedRC=200"},
+		}
+		for _, test := range tests {
+			r := []string{"2024-05-01", "10:00:00", "0", "0", "0", test.record[2], test.record[1], "200",
+				test.record[0]}
+			out := captureStdout(t, func() { perform(r, options{}) })
+			if !strings.Contains(out, test.want) || strings.Count(out, "\n") != 1 {
+				t.Errorf("%s %s printed %q, want one line with %q", test.record[0], test.record[1], out, test.want)
+			}
+		}
+
+		// and the objects are as the sdk sees them
+		list, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("test")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, o := range list.Contents {
+			keys = append(keys, *o.Key)
+		}
+		if strings.Join(keys, " ") != "dir/big small" {
+			t.Errorf("listed %q, want dir/big and small", keys)
+		}
+		list, err = svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("test"),
+			Prefix: aws.String("dir/")})
+		if err != nil || len(list.Contents) != 1 {
+			t.Errorf("listing dir/ gave %v, %v, want dir/big", list, err)
+		}
+		head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("test"), Key: aws.String("dir/big")})
+		if err != nil || *head.ContentLength != 6000000 || !strings.HasSuffix(*head.ETag, `-2"`) {
+			t.Errorf("head of dir/big gave %v, %v, want 6000000 bytes from two parts", head, err)
+		}
+
+		// the content survives the round trip
+		want, _ := io.ReadAll(newContent("dir/big", 6000000))
+		buf := aws.NewWriteAtBuffer(nil)
+		if _, err = s3manager.NewDownloaderWithClient(svc).Download(buf, &s3.GetObjectInput{
+			Bucket: aws.String("test"), Key: aws.String("dir/big")}); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("dir/big came back changed")
+		}
+
+		if _, err = svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("test"),
+			Key: aws.String("small")}); err != nil {
+			t.Errorf("delete failed, %v", err)
+		}
+		if _, err = svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("test"),
+			Key: aws.String("small")}); errorCodeToHTTPCode(err) != 404 {
+			t.Errorf("head of a deleted object gave %v, want a 404", err)
+		}
+		if _, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("nobucket"),
+			Key: aws.String("small")}); err == nil || !strings.Contains(err.Error(), "NoSuchBucket") {
+			t.Errorf("get from a missing bucket gave %v, want NoSuchBucket", err)
+		}
+	})
+}
+
+// TestS3ProtoErrors has the simulator refuse everything
+func TestS3ProtoErrors(t *testing.T) {
+	withS3(t, Config{SimErrors: "403:1"}, func(url string) {
+		out := captureStdout(t, func() {
+			perform([]string{"2024-05-01", "10:00:00", "0", "0", "0", "10", "small", "201", "PUT"}, options{})
+		})
+		if !strings.Contains(out, " 10 small 403 PUT") {
+			t.Errorf("PUT printed %q, want a 403", out)
+		}
+	})
+}
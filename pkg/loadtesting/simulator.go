@@ -0,0 +1,586 @@
+package loadtesting
+
+// Simulate a system under test, so tests can be developed and checked
+// without real hardware. Each request takes a time drawn from the
+// distribution for its path, holding one of a limited number of
+// service slots, and waits in a queue when they're all busy. That
+// produces a genuine hockey-stick: response time stays flat until the
+// request rate nears slots / mean service time, then rises steeply.
+//
+// As a queueing model, an M/M/c or M/D/c queue, it has a capacity known
+// in advance, and reports its true utilisation and queue length at
+// statsPath, so the analysis of a test against it can be checked.
+//
+// It can also be a small S3, for rehearsing S3 tests, with the same
+// latencies and injected errors.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsPath is where the simulator reports on itself
+const statsPath = "/_dummy/stats"
+
+// simulator satisfies http.Handler by pretending to do work
+type simulator struct {
+	routes   []simRoute
+	sizes    map[string]int64 // path to response size, from a perf file
+	anySize  []int64          // sizes for paths not in the file
+	errors   []simError
+	slots    chan struct{} // service slots, or nil for no limit
+	maxQueue int           // requests allowed to wait, 0 for any number
+	model    string        // M/M/c or M/D/c, if we're one
+	service  time.Duration // the model's mean service time
+	s3       *s3Server     // if we're pretending to be S3
+	stats    simStats
+
+	mu     sync.Mutex
+	stored map[string][]byte // bodies that were PUT
+}
+
+// simRoute is a path pattern and the service time of requests matching it
+type simRoute struct {
+	re      *regexp.Regexp
+	latency distribution
+}
+
+// simError is a return code injected into some fraction of responses
+type simError struct {
+	rc   int
+	rate float64
+}
+
+// simStats accumulates the time spent busy and queued, which is what
+// utilisation and mean queue length are made from
+type simStats struct {
+	mu           sync.Mutex
+	start, last  time.Time
+	busy, queued int
+	busySeconds  float64 // integral of busy over time
+	queueSeconds float64 // integral of queued over time
+	arrivals     int64
+	completed    int64
+	rejected     int64
+}
+
+// simReport is what statsPath returns, in JSON
+type simReport struct {
+	Model             string  `json:"model,omitempty"`
+	Servers           int     `json:"servers,omitempty"`
+	Service           float64 `json:"service,omitempty"`  // mean service time, seconds
+	Capacity          float64 `json:"capacity,omitempty"` // requests/second
+	Uptime            float64 `json:"uptime"`
+	Arrivals          int64   `json:"arrivals"`
+	Completed         int64   `json:"completed"`
+	Rejected          int64   `json:"rejected"`
+	Busy              int     `json:"busy"`
+	Queued            int     `json:"queued"`
+	BusySeconds       float64 `json:"busySeconds"`
+	QueueSeconds      float64 `json:"queueSeconds"`
+	Utilisation       float64 `json:"utilisation,omitempty"`
+	MeanQueue         float64 `json:"meanQueue"`
+	ArrivalRate       float64 `json:"arrivalRate"`
+	PredictedResponse float64 `json:"predictedResponse,omitempty"` // seconds, at the arrival rate
+}
+
+// distribution is a random service time
+type distribution struct {
+	kind string // fixed, uniform, normal or exp
+	a, b time.Duration
+}
+
+// Simulate listens on addr, serving requests as cfg asks until killed
+func Simulate(addr string, cfg Config) {
+	conf = cfg
+	s, err := newSimulator(cfg)
+	if err != nil {
+		log.Fatalf("Fatal error setting up the simulator: %s, halting\n", err)
+	}
+	log.Printf("Simulating a server on %s\n", addr)
+	log.Fatal(http.ListenAndServe(addr, s))
+}
+
+// newSimulator makes a simulator from the Sim options
+func newSimulator(cfg Config) (*simulator, error) {
+	var err error
+
+	s := &simulator{
+		maxQueue: cfg.SimQueue,
+		stored:   make(map[string][]byte),
+	}
+	s.stats.start = time.Now()
+	s.stats.last = s.stats.start
+	if s.routes, err = parseRoutes(cfg.SimLatency); err != nil {
+		return nil, err
+	}
+	if s.errors, err = parseSimErrors(cfg.SimErrors); err != nil {
+		return nil, err
+	}
+	if cfg.SimSizes != "" {
+		if s.sizes, s.anySize, err = loadSizes(cfg.SimSizes); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SimConcurrency > 0 {
+		s.slots = make(chan struct{}, cfg.SimConcurrency)
+	}
+	if cfg.SimS3 {
+		s.s3 = newS3Server(strings.Split(cfg.SimBuckets, ",")...)
+	}
+	if cfg.SimModel != "" {
+		if err = s.setModel(cfg.SimModel, cfg.SimServers, cfg.SimService); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// setModel makes the simulator an M/M/c queue, with exponential service
+// times, or an M/D/c queue, with fixed ones. It replaces any latencies,
+// concurrency and queue limit. The arrivals are whatever the load
+// generator makes them, which for runLoadTest are close to random.
+func (s *simulator) setModel(model string, servers int, service time.Duration) error {
+	var kind string
+
+	switch strings.ToUpper(model) {
+	case "M/M/C":
+		kind = "exp"
+	case "M/D/C":
+		kind = "fixed"
+	default:
+		return fmt.Errorf("unknown model %q, expected M/M/c or M/D/c", model)
+	}
+	if servers < 1 || service <= 0 {
+		return fmt.Errorf("model %s needs at least one server and a service time", model)
+	}
+	s.model = strings.ToUpper(model[:4]) + "c"
+	s.service = service
+	s.routes = []simRoute{{re: regexp.MustCompile("."), latency: distribution{kind: kind, a: service}}}
+	s.slots = make(chan struct{}, servers)
+	s.maxQueue = 0
+	return nil
+}
+
+// ServeHTTP queues for a slot, takes the path's service time and replies
+func (s *simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if conf.Debug {
+		log.Printf("in simulator.ServeHTTP(%s %s)\n", r.Method, r.URL)
+	}
+	if r.URL.Path == statsPath {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.report()) // nolint
+		return
+	}
+	if !s.acquire(w, r) {
+		return
+	}
+	defer s.release()
+	time.Sleep(s.serviceTime(r.URL.Path))
+
+	rc := s.injectedError()
+	switch {
+	case rc != 0 && s.s3 != nil:
+		writeS3Error(w, rc, s3ErrorCode(rc), "injected error", r.URL.Path)
+		return
+	case rc != 0:
+		http.Error(w, http.StatusText(rc), rc)
+		return
+	case s.s3 != nil:
+		s.s3.ServeHTTP(w, r)
+		return
+	}
+	switch r.Method {
+	case "PUT", "POST":
+		s.put(w, r)
+	case "DELETE":
+		s.delete(w, r)
+	default:
+		s.get(w, r)
+	}
+}
+
+// acquire waits for a service slot, if there are a limited number,
+// returning false if the queue is full or the client gave up
+func (s *simulator) acquire(w http.ResponseWriter, r *http.Request) bool {
+	s.stats.arrive()
+	if s.slots == nil {
+		s.stats.change(1, 0)
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		s.stats.change(1, 0)
+		return true
+	default:
+	}
+	// all busy, so wait if there's room in the queue
+	if !s.stats.enqueue(s.maxQueue) {
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
+		return false
+	}
+	select {
+	case s.slots <- struct{}{}:
+		s.stats.change(1, -1)
+		return true
+	case <-r.Context().Done():
+		s.stats.change(0, -1)
+		return false
+	}
+}
+
+// release frees a slot, once a request is served
+func (s *simulator) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+	s.stats.change(-1, 0)
+	s.stats.complete()
+}
+
+// report is the stats as of now
+func (s *simulator) report() simReport {
+	st := &s.stats
+	st.mu.Lock()
+	st.update(0, 0) // bring the integrals up to date
+	rep := simReport{
+		Model:        s.model,
+		Uptime:       time.Since(st.start).Seconds(),
+		Arrivals:     st.arrivals,
+		Completed:    st.completed,
+		Rejected:     st.rejected,
+		Busy:         st.busy,
+		Queued:       st.queued,
+		BusySeconds:  st.busySeconds,
+		QueueSeconds: st.queueSeconds,
+	}
+	st.mu.Unlock()
+
+	rep.MeanQueue = rep.QueueSeconds / rep.Uptime
+	rep.ArrivalRate = float64(rep.Arrivals) / rep.Uptime
+	if s.slots != nil {
+		rep.Servers = cap(s.slots)
+		rep.Utilisation = rep.BusySeconds / (float64(rep.Servers) * rep.Uptime)
+	}
+	if s.model != "" {
+		rep.Service = s.service.Seconds()
+		rep.Capacity = float64(rep.Servers) / rep.Service
+		rep.PredictedResponse = PredictedResponse(s.model, rep.Servers, s.service,
+			rep.ArrivalRate).Seconds()
+	}
+	return rep
+}
+
+// arrive counts a request
+func (st *simStats) arrive() {
+	st.mu.Lock()
+	st.arrivals++
+	st.mu.Unlock()
+}
+
+// complete counts a request served
+func (st *simStats) complete() {
+	st.mu.Lock()
+	st.completed++
+	st.mu.Unlock()
+}
+
+// enqueue adds a request to the queue, or counts it as rejected if
+// there are already max waiting
+func (st *simStats) enqueue(max int) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if max > 0 && st.queued >= max {
+		st.rejected++
+		return false
+	}
+	st.update(0, 1)
+	return true
+}
+
+// change adjusts the numbers busy and queued
+func (st *simStats) change(busy, queued int) {
+	st.mu.Lock()
+	st.update(busy, queued)
+	st.mu.Unlock()
+}
+
+// update adds to the integrals the time since the last change, then
+// makes the new one. The lock must be held.
+func (st *simStats) update(busy, queued int) {
+	now := time.Now()
+	dt := now.Sub(st.last).Seconds()
+	st.busySeconds += float64(st.busy) * dt
+	st.queueSeconds += float64(st.queued) * dt
+	st.busy += busy
+	st.queued += queued
+	st.last = now
+}
+
+// PredictedResponse is the mean response time of an M/M/c or M/D/c
+// queue with servers servers, each taking service on average, at an
+// arrival rate of lambda requests/second. It's from Erlang's C formula,
+// with M/D/c's waiting time taken as half M/M/c's, after Allen and
+// Cunneen. A saturated queue has no mean, and gets the largest time.
+func PredictedResponse(model string, servers int, service time.Duration, lambda float64) time.Duration {
+	a := lambda * service.Seconds() // offered load, in Erlangs
+	c := float64(servers)
+	if a >= c {
+		return time.Duration(math.MaxInt64)
+	}
+	wait := erlangC(servers, a) * service.Seconds() / (c - a)
+	if strings.EqualFold(model, "M/D/c") {
+		wait /= 2
+	}
+	return service + time.Duration(wait*float64(time.Second))
+}
+
+// erlangC is the probability that an arrival has to wait, in an M/M/c
+// queue with offered load a < c
+func erlangC(c int, a float64) float64 {
+	// the Erlang B formula by its recurrence, then C from B
+	b := 1.0
+	for k := 1; k <= c; k++ {
+		b = a * b / (float64(k) + a*b)
+	}
+	rho := a / float64(c)
+	return b / (1 - rho + rho*b)
+}
+
+// put stores the body, to be served back
+func (s *simulator) put(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error reading request body", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	_, existed := s.stored[r.URL.Path]
+	s.stored[r.URL.Path] = body
+	s.mu.Unlock()
+	if existed {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// delete forgets a stored body
+func (s *simulator) delete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	_, existed := s.stored[r.URL.Path]
+	delete(s.stored, r.URL.Path)
+	s.mu.Unlock()
+	if !existed {
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// get serves a stored body, or one of the size the perf file says, or
+// failing both, the path it was asked for
+func (s *simulator) get(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	body, ok := s.stored[r.URL.Path]
+	s.mu.Unlock()
+	switch {
+	case ok:
+		w.Write(body) // nolint
+	case s.sizes != nil:
+		size, ok := s.sizes[r.URL.Path]
+		if !ok {
+			size = s.anySize[rand.Intn(len(s.anySize))]
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		writeFiller(w, size)
+	default:
+		param := strings.TrimPrefix(r.URL.Path, "/")
+		if param == "" {
+			param = "nothing at all"
+		}
+		fmt.Fprintf(w, "Got %q\n", param)
+	}
+}
+
+// serviceTime is a sample from the first route matching path
+func (s *simulator) serviceTime(path string) time.Duration {
+	for _, route := range s.routes {
+		if route.re.MatchString(path) {
+			return route.latency.sample()
+		}
+	}
+	return 0
+}
+
+// injectedError is a return code to fail with, or 0 for none
+func (s *simulator) injectedError() int {
+	x := rand.Float64()
+	for _, e := range s.errors {
+		if x < e.rate {
+			return e.rc
+		}
+		x -= e.rate
+	}
+	return 0
+}
+
+// writeFiller writes size bytes of padding
+func writeFiller(w io.Writer, size int64) {
+	var filler [32 * 1024]byte
+
+	for size > 0 {
+		chunk := filler[:]
+		if size < int64(len(chunk)) {
+			chunk = chunk[:size]
+		}
+		n, err := w.Write(chunk)
+		if err != nil {
+			return
+		}
+		size -= int64(n)
+	}
+}
+
+// parseRoutes parses "regexp=distribution;..." as in
+// "^/api/=exp:20ms;.=normal:5ms,1ms". The first match wins.
+func parseRoutes(s string) ([]simRoute, error) {
+	var routes []simRoute
+
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.LastIndex(spec, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("latency %q is not pattern=distribution", spec)
+		}
+		re, err := regexp.Compile(spec[:i])
+		if err != nil {
+			return nil, fmt.Errorf("bad latency pattern %q, %v", spec[:i], err)
+		}
+		d, err := parseDistribution(spec[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, simRoute{re: re, latency: d})
+	}
+	return routes, nil
+}
+
+// parseDistribution parses fixed:d, uniform:min,max, normal:mean,stddev
+// or exp:mean, with times like 20ms
+func parseDistribution(s string) (distribution, error) {
+	kind, params, _ := strings.Cut(s, ":")
+	var times []time.Duration
+	for _, p := range strings.Split(params, ",") {
+		t, err := time.ParseDuration(strings.TrimSpace(p))
+		if err != nil {
+			return distribution{}, fmt.Errorf("bad time %q in distribution %q, %v", p, s, err)
+		}
+		times = append(times, t)
+	}
+	want := map[string]int{"fixed": 1, "uniform": 2, "normal": 2, "exp": 1}
+	n, ok := want[kind]
+	if !ok {
+		return distribution{}, fmt.Errorf("unknown distribution %q, expected fixed, uniform, normal or exp", kind)
+	}
+	if len(times) != n {
+		return distribution{}, fmt.Errorf("distribution %q needs %d times", s, n)
+	}
+	d := distribution{kind: kind, a: times[0]}
+	if n == 2 {
+		d.b = times[1]
+	}
+	return d, nil
+}
+
+// sample draws a time, never less than zero
+func (d distribution) sample() time.Duration {
+	var t float64
+
+	switch d.kind {
+	case "fixed":
+		t = float64(d.a)
+	case "uniform":
+		t = float64(d.a) + rand.Float64()*float64(d.b-d.a)
+	case "normal":
+		t = float64(d.a) + rand.NormFloat64()*float64(d.b)
+	case "exp":
+		t = rand.ExpFloat64() * float64(d.a)
+	}
+	return time.Duration(math.Max(t, 0))
+}
+
+// parseSimErrors parses "rc:rate;..." as in "503:0.01;500:0.001"
+func parseSimErrors(s string) ([]simError, error) {
+	var errors []simError
+	var total float64
+
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		code, fraction, found := strings.Cut(spec, ":")
+		rc, err := strconv.Atoi(code)
+		if !found || err != nil || rc < 100 || rc > 999 {
+			return nil, fmt.Errorf("error %q is not rc:rate", spec)
+		}
+		rate, err := strconv.ParseFloat(fraction, 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("error rate %q is not a fraction", fraction)
+		}
+		total += rate
+		errors = append(errors, simError{rc: rc, rate: rate})
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("error rates add up to %g, more than 1", total)
+	}
+	return errors, nil
+}
+
+// loadSizes reads the response size of each path from a perf file's
+// successful GETs, and all the sizes, for paths it doesn't mention
+func loadSizes(filename string) (map[string]int64, []int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close() // nolint
+	r, err := newRecordReader("perf", f)
+	if err != nil {
+		return nil, nil, err
+	}
+	sizes := make(map[string]int64)
+	var all []int64
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || checkRecord(record) != nil ||
+			record[operatorField] != "GET" || record[returnCodeField] != "200" {
+			continue
+		}
+		size, _ := strconv.ParseInt(record[bytesField], 10, 64)
+		sizes[record[pathField]] = size
+		all = append(all, size)
+	}
+	if len(all) == 0 {
+		return nil, nil, fmt.Errorf("no successful GETs in %s to take sizes from", filename)
+	}
+	return sizes, all, nil
+}
@@ -0,0 +1,148 @@
+package loadtesting
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSimulatorOptions checks the option parsers
+func TestSimulatorOptions(t *testing.T) {
+	tests := []struct {
+		latency, errors string
+		ok              bool
+	}{
+		{"", "", true},
+		{"^/api/=exp:20ms;.=normal:5ms,1ms", "503:0.01;500:0.001", true},
+		{"a=b=fixed:1s", "", true},
+		{"x=uniform:1ms,2ms", "", true},
+		{"x=uniform:1ms", "", false},
+		{"x=zipf:1ms", "", false},
+		{"fixed:1ms", "", false},
+		{"(=fixed:1ms", "", false},
+		{"", "503", false},
+		{"", "503:0.6;500:0.6", false},
+		{"", "42:0.1", false},
+	}
+	for _, test := range tests {
+		_, err := newSimulator(Config{SimLatency: test.latency, SimErrors: test.errors})
+		if (err == nil) != test.ok {
+			t.Errorf("latency %q, errors %q gave %v, want ok=%v", test.latency, test.errors, err, test.ok)
+		}
+	}
+}
+
+// TestSimulator serves stored bodies, perf file sizes and injected errors
+func TestSimulator(t *testing.T) {
+	perf := filepath.Join(t.TempDir(), "load.csv")
+	err := os.WriteFile(perf, []byte("2024-05-01 10:00:00 0 0 0 1234 /big 200 GET\n"+
+		"2024-05-01 10:00:00 0 0 0 99 /missing 404 GET\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSimulator(Config{SimSizes: perf, SimLatency: "^/slow=fixed:50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	tests := []struct {
+		method, path, body string
+		rc                 int
+		want               string
+		size               int
+	}{
+		{"GET", "/big", "", 200, "", 1234},
+		{"GET", "/other", "", 200, "", 1234},
+		{"PUT", "/stored", "hello", 201, "", 0},
+		{"GET", "/stored", "", 200, "hello", 5},
+		{"PUT", "/stored", "again", 200, "", 0},
+		{"GET", "/stored", "", 200, "again", 5},
+		{"DELETE", "/stored", "", 204, "", 0},
+		{"DELETE", "/stored", "", 404, "", -1},
+	}
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close() // nolint
+		if resp.StatusCode != test.rc || (test.want != "" && string(body) != test.want) ||
+			(test.size >= 0 && len(body) != test.size) {
+			t.Errorf("%s %s gave %d %q, want %d %q of %d bytes", test.method, test.path,
+				resp.StatusCode, body, test.rc, test.want, test.size)
+		}
+	}
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close() // nolint
+	if took := time.Since(start); took < 50*time.Millisecond {
+		t.Errorf("/slow took %s, want at least 50ms", took)
+	}
+
+	s.errors = []simError{{rc: 503, rate: 1}}
+	resp, err = http.Get(server.URL + "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close() // nolint
+	if resp.StatusCode != 503 {
+		t.Errorf("with a 503 rate of 1, got %d", resp.StatusCode)
+	}
+}
+
+// TestSimulatorQueueing has concurrent requests wait for one slot, and
+// turns away those that don't fit in the queue
+func TestSimulatorQueueing(t *testing.T) {
+	s, err := newSimulator(Config{SimLatency: ".=fixed:100ms", SimConcurrency: 1, SimQueue: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	var slowest time.Duration
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			resp, err := http.Get(server.URL + "/x")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close() // nolint
+			mu.Lock()
+			defer mu.Unlock()
+			codes[resp.StatusCode]++
+			if took := time.Since(start); took > slowest {
+				slowest = took
+			}
+		}()
+		time.Sleep(20 * time.Millisecond) // so they arrive in order
+	}
+	wg.Wait()
+	if codes[200] != 2 || codes[503] != 1 {
+		t.Errorf("got return codes %v, want two 200s and one 503", codes)
+	}
+	if slowest < 150*time.Millisecond {
+		t.Errorf("slowest request took %s, want at least 150ms after waiting its turn", slowest)
+	}
+}
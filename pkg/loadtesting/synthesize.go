@@ -0,0 +1,481 @@
+package loadtesting
+
+// synthesize makes a perf file from a statistical model of a workload,
+// for when there's no log to replay: a new service, or one whose logs
+// we can't have. The model is json, and says what mix of operations to
+// do, how popular each object is, how big they are, how fast requests
+// arrive and what return codes to expect. The same model and seed make
+// the same file, on every run and every machine.
+//
+// Object n's path is the model's paths with n in it, as in
+// /object-17.jpg. An object keeps the size it was first given, so
+// mkLoadTestFiles creates what the GETs expect, and -verify agrees.
+// GETs expecting a code that doesn't create a file, such as a 404, and
+// DELETEs are of paths of their own, so they don't spoil the objects
+// other requests use.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workloadModel is a model of a workload, as read from a model file
+type workloadModel struct {
+	Seed       int64                         `json:"seed"`
+	Start      string                        `json:"start,omitempty"`      // of the first request, 2006-01-02 15:04:05
+	Paths      string                        `json:"paths,omitempty"`      // of objects, with a %d for their number
+	Objects    int                           `json:"objects"`              // there at the start
+	NewObjects float64                       `json:"newObjects,omitempty"` // fraction of requests for a new object
+	Popularity popularity                    `json:"popularity"`
+	Ops        map[string]float64            `json:"ops"`             // operation to weight
+	Sizes      map[string]sizeDistribution   `json:"sizes,omitempty"` // by operation, in bytes
+	Codes      map[string]map[string]float64 `json:"codes,omitempty"` // by operation, code to weight
+	Rates      []rateStep                    `json:"rates"`           // requests/second over time
+	Arrivals   sizeDistribution              `json:"arrivals"`        // shape of the gaps between them
+}
+
+// popularity is how requests are shared among objects
+type popularity struct {
+	Kind  string  `json:"kind"`            // uniform, zipf or hotset
+	S     float64 `json:"s,omitempty"`     // zipf exponent, 1 is classic zipf
+	Hot   float64 `json:"hot,omitempty"`   // hotset fraction of objects
+	Share float64 `json:"share,omitempty"` // and the fraction of requests they get
+}
+
+// sizeDistribution is a distribution of sizes, or of gaps between
+// arrivals, as numbers rather than simulator times
+type sizeDistribution struct {
+	Kind      string    `json:"kind,omitempty"` // fixed, uniform, exp, lognormal or empirical
+	Value     float64   `json:"value,omitempty"`
+	Min       float64   `json:"min,omitempty"`
+	Max       float64   `json:"max,omitempty"`
+	Mean      float64   `json:"mean,omitempty"`
+	Mu        float64   `json:"mu,omitempty"`        // lognormal mean of the log
+	Sigma     float64   `json:"sigma,omitempty"`     // and its standard deviation
+	Quantiles []float64 `json:"quantiles,omitempty"` // empirical, evenly spaced from min to max
+}
+
+// rateStep is a period of the arrival rate profile, steady or a ramp
+type rateStep struct {
+	Seconds float64  `json:"seconds"`
+	Rate    float64  `json:"rate"`
+	To      *float64 `json:"to,omitempty"` // ramp from rate to this
+}
+
+// synthOps are the operations a model may have. POSTs need bodies,
+// which we can't make up.
+var synthOps = map[string]bool{"GET": true, "PUT": true, "DELETE": true}
+
+// defaultCodes are what we expect of an operation without codes
+var defaultCodes = map[string]string{"GET": "200", "PUT": "201", "DELETE": "204"}
+
+// Synthesize reads a model file and writes the perf file it describes to stdout
+func Synthesize(f *os.File, filename string, cfg Config) {
+	conf = cfg
+	if conf.Debug {
+		log.Printf("in Synthesize(f *os.File, filename=%s)\n", filename)
+	}
+	m, err := readModel(f)
+	if err != nil {
+		log.Fatalf("Fatal error reading model %s: %s, halting\n", filename, err)
+	}
+	if conf.SynthSeed != 0 {
+		m.Seed = conf.SynthSeed
+	}
+	m.scale(conf.SynthScale, conf.SynthLonger)
+	if err = synthesize(m, os.Stdout); err != nil {
+		log.Fatalf("Fatal error writing: %s, halting\n", err)
+	}
+}
+
+// readModel reads and checks a model, filling in the defaults
+func readModel(r io.Reader) (*workloadModel, error) {
+	var m workloadModel
+
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&m); err != nil {
+		return nil, err
+	}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// check rejects models we can't generate from, and fills in defaults
+func (m *workloadModel) check() error {
+	if m.Paths == "" {
+		m.Paths = "/object-%d"
+	}
+	if strings.Count(m.Paths, "%d") != 1 || strings.Count(m.Paths, "%") != 1 {
+		return fmt.Errorf("paths %q needs exactly one %%d", m.Paths)
+	}
+	if m.Start == "" {
+		m.Start = "2000-01-01 00:00:00"
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05", m.Start); err != nil {
+		return fmt.Errorf("start %q is not yyyy-mm-dd hh:mm:ss", m.Start)
+	}
+	if m.NewObjects < 0 || m.NewObjects > 1 {
+		return fmt.Errorf("newObjects %g is not between 0 and 1", m.NewObjects)
+	}
+	if m.Objects < 1 && m.NewObjects == 0 {
+		return fmt.Errorf("there are no objects, and no new ones")
+	}
+	if err := m.Popularity.check(); err != nil {
+		return err
+	}
+
+	if err := checkWeights("ops", m.Ops); err != nil {
+		return err
+	}
+	if m.Codes == nil {
+		m.Codes = make(map[string]map[string]float64)
+	}
+	for op := range m.Ops {
+		if !synthOps[op] {
+			return fmt.Errorf("operation %q is not GET, PUT or DELETE", op)
+		}
+		if m.Ops[op] == 0 {
+			continue
+		}
+		if _, ok := m.Sizes[op]; !ok && op != "DELETE" {
+			return fmt.Errorf("operation %s has no sizes", op)
+		}
+		if _, ok := m.Codes[op]; !ok {
+			m.Codes[op] = map[string]float64{defaultCodes[op]: 1}
+		}
+	}
+	for op, d := range m.Sizes {
+		if err := d.check(); err != nil {
+			return fmt.Errorf("sizes of %s: %v", op, err)
+		}
+	}
+	for op, codes := range m.Codes {
+		if err := checkWeights("codes of "+op, codes); err != nil {
+			return err
+		}
+		for code := range codes {
+			if _, err := strconv.Atoi(code); err != nil {
+				return fmt.Errorf("code %q of %s is not a number", code, op)
+			}
+		}
+	}
+
+	if len(m.Rates) == 0 {
+		return fmt.Errorf("there are no rates")
+	}
+	for _, step := range m.Rates {
+		if step.Seconds <= 0 || step.Rate < 0 || (step.To != nil && *step.To < 0) {
+			return fmt.Errorf("rate %g for %g seconds is not a positive time at a rate of zero or more",
+				step.Rate, step.Seconds)
+		}
+	}
+	if m.Arrivals.Kind == "" {
+		// Poisson arrivals
+		m.Arrivals = sizeDistribution{Kind: "exp", Mean: 1}
+	}
+	if err := m.Arrivals.check(); err != nil {
+		return fmt.Errorf("arrivals: %v", err)
+	}
+	if m.Arrivals.mean() <= 0 {
+		return fmt.Errorf("arrivals have a mean of zero")
+	}
+	return nil
+}
+
+// checkWeights rejects negative weights and all zeros
+func checkWeights(name string, weights map[string]float64) error {
+	var total float64
+	for k, w := range weights {
+		if w < 0 {
+			return fmt.Errorf("%s: %s has a negative weight, %g", name, k, w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return fmt.Errorf("%s: there are none with any weight", name)
+	}
+	return nil
+}
+
+// check rejects unknown kinds of popularity and impossible parameters
+func (p *popularity) check() error {
+	switch p.Kind {
+	case "", "uniform":
+		p.Kind = "uniform"
+	case "zipf":
+		if p.S <= 0 {
+			return fmt.Errorf("zipf popularity needs an s above zero")
+		}
+	case "hotset":
+		if p.Hot <= 0 || p.Hot > 1 || p.Share < 0 || p.Share > 1 {
+			return fmt.Errorf("hotset popularity needs a hot fraction above zero and a share, both up to 1")
+		}
+	default:
+		return fmt.Errorf("unknown popularity %q, expected uniform, zipf or hotset", p.Kind)
+	}
+	return nil
+}
+
+// check rejects unknown distributions and impossible parameters
+func (d sizeDistribution) check() error {
+	switch d.Kind {
+	case "fixed":
+		if d.Value < 0 {
+			return fmt.Errorf("fixed needs a value of zero or more")
+		}
+	case "uniform":
+		if d.Min < 0 || d.Max < d.Min {
+			return fmt.Errorf("uniform needs 0 <= min <= max")
+		}
+	case "exp":
+		if d.Mean <= 0 {
+			return fmt.Errorf("exp needs a mean above zero")
+		}
+	case "lognormal":
+		if d.Sigma < 0 {
+			return fmt.Errorf("lognormal needs a sigma of zero or more")
+		}
+	case "empirical":
+		if len(d.Quantiles) < 2 || !sort.Float64sAreSorted(d.Quantiles) || d.Quantiles[0] < 0 {
+			return fmt.Errorf("empirical needs two or more quantiles, in order, from zero up")
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q, expected fixed, uniform, exp, lognormal or empirical", d.Kind)
+	}
+	return nil
+}
+
+// sample draws a value, never less than zero
+func (d sizeDistribution) sample(r *rand.Rand) float64 {
+	var v float64
+
+	switch d.Kind {
+	case "fixed":
+		v = d.Value
+	case "uniform":
+		v = d.Min + r.Float64()*(d.Max-d.Min)
+	case "exp":
+		v = r.ExpFloat64() * d.Mean
+	case "lognormal":
+		v = math.Exp(d.Mu + r.NormFloat64()*d.Sigma)
+	case "empirical":
+		// interpolate between the quantiles either side
+		x := r.Float64() * float64(len(d.Quantiles)-1)
+		i := int(x)
+		if i == len(d.Quantiles)-1 {
+			i--
+		}
+		v = d.Quantiles[i] + (x-float64(i))*(d.Quantiles[i+1]-d.Quantiles[i])
+	}
+	return math.Max(v, 0)
+}
+
+// mean is the distribution's mean
+func (d sizeDistribution) mean() float64 {
+	switch d.Kind {
+	case "fixed":
+		return d.Value
+	case "uniform":
+		return (d.Min + d.Max) / 2
+	case "exp":
+		return d.Mean
+	case "lognormal":
+		return math.Exp(d.Mu + d.Sigma*d.Sigma/2)
+	case "empirical":
+		var total float64
+		for i := 1; i < len(d.Quantiles); i++ {
+			total += (d.Quantiles[i-1] + d.Quantiles[i]) / 2
+		}
+		return total / float64(len(d.Quantiles)-1)
+	}
+	return 0
+}
+
+// scale multiplies the rates by rate and the durations by longer, for
+// a bigger or a longer test of the same shape. Zero leaves them be.
+func (m *workloadModel) scale(rate, longer float64) {
+	for i := range m.Rates {
+		if rate > 0 {
+			m.Rates[i].Rate *= rate
+			if m.Rates[i].To != nil {
+				to := *m.Rates[i].To * rate
+				m.Rates[i].To = &to
+			}
+		}
+		if longer > 0 {
+			m.Rates[i].Seconds *= longer
+		}
+	}
+}
+
+// rateAt is the arrival rate t seconds in, and whether t is before the end
+func (m *workloadModel) rateAt(t float64) (float64, bool) {
+	for _, step := range m.Rates {
+		if t < step.Seconds {
+			if step.To == nil {
+				return step.Rate, true
+			}
+			return step.Rate + (*step.To-step.Rate)*t/step.Seconds, true
+		}
+		t -= step.Seconds
+	}
+	return 0, false
+}
+
+// generator holds the state of a synthesis
+type generator struct {
+	m       *workloadModel
+	r       *rand.Rand
+	objects int     // objects so far
+	sizes   []int64 // of each object, -1 if not yet known
+	others  int     // paths used by one request
+	ops     []string
+	codes   map[string][]string
+}
+
+// synthesize writes the records of a model to w
+func synthesize(m *workloadModel, w io.Writer) error {
+	g := &generator{
+		m:       m,
+		r:       rand.New(rand.NewSource(m.Seed)),
+		objects: m.Objects,
+		sizes:   make([]int64, m.Objects),
+		ops:     weightedKeys(m.Ops),
+		codes:   make(map[string][]string),
+	}
+	for i := range g.sizes {
+		g.sizes[i] = -1
+	}
+	for op, codes := range m.Codes {
+		g.codes[op] = weightedKeys(codes)
+	}
+	start, _ := time.Parse("2006-01-02 15:04:05", m.Start)
+	gapScale := 1 / m.Arrivals.mean()
+
+	if _, err := fmt.Fprintln(w, "#yyy-mm-dd hh:mm:ss latency xfertime sleeptime bytes url rc op"); err != nil {
+		return err
+	}
+	for t := 0.0; ; {
+		rate, ok := m.rateAt(t)
+		if !ok {
+			break
+		}
+		if rate <= 0 {
+			// nothing arrives, so look again in a second
+			t = math.Floor(t) + 1
+			continue
+		}
+		op := g.choose(g.ops, m.Ops)
+		code := g.choose(g.codes[op], m.Codes[op])
+		path, size := g.request(op, code)
+		at := start.Add(time.Duration(t * float64(time.Second)))
+		if _, err := fmt.Fprintf(w, "%s 0 0 0 %d %s %s %s\n",
+			at.Format("2006-01-02 15:04:05.000"), size, path, code, op); err != nil {
+			return err
+		}
+		t += m.Arrivals.sample(g.r) * gapScale / rate
+	}
+	return nil
+}
+
+// weightedKeys returns the keys of a weight map in order
+func weightedKeys(weights map[string]float64) []string {
+	keys := make([]string, 0, len(weights))
+	for k := range weights {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// choose picks one of keys, in proportion to their weights. The keys
+// are sorted, so the same seed picks the same ones.
+func (g *generator) choose(keys []string, weights map[string]float64) string {
+	var total float64
+	for _, k := range keys {
+		total += weights[k]
+	}
+	x := g.r.Float64() * total
+	for _, k := range keys {
+		x -= weights[k]
+		if x < 0 && weights[k] > 0 {
+			return k
+		}
+	}
+	// rounding, so the last with any weight
+	for i := len(keys) - 1; ; i-- {
+		if weights[keys[i]] > 0 {
+			return keys[i]
+		}
+	}
+}
+
+// request picks the path and size of a request
+func (g *generator) request(op, code string) (string, int64) {
+	rc, _ := strconv.Atoi(code)
+	_, create := codeDescr(rc)
+	switch {
+	case op == "DELETE":
+		// mkLoadTestFiles creates an empty file, just to delete
+		return g.otherPath("deleted"), 0
+	case op == "GET" && !create:
+		return g.otherPath("missing"), 0
+	}
+
+	n := g.object()
+	if g.sizes[n] < 0 {
+		g.sizes[n] = int64(g.m.Sizes[op].sample(g.r))
+	}
+	return fmt.Sprintf(g.m.Paths, n), g.sizes[n]
+}
+
+// object picks which object a request is for, perhaps a new one
+func (g *generator) object() int {
+	if g.objects == 0 || g.r.Float64() < g.m.NewObjects {
+		g.objects++
+		g.sizes = append(g.sizes, -1)
+		return g.objects - 1
+	}
+	n := float64(g.objects)
+	p := g.m.Popularity
+	switch p.Kind {
+	case "zipf":
+		// the inverse of the continuous zipf distribution on 1..n+1,
+		// which is close to the discrete one, and allows for n growing
+		var x float64
+		u := g.r.Float64()
+		if p.S == 1 {
+			x = math.Pow(n+1, u)
+		} else {
+			x = math.Pow((math.Pow(n+1, 1-p.S)-1)*u+1, 1/(1-p.S))
+		}
+		return min(int(x)-1, g.objects-1)
+	case "hotset":
+		hot := max(int(p.Hot*n), 1)
+		if g.r.Float64() < p.Share || hot == g.objects {
+			return g.r.Intn(hot)
+		}
+		return hot + g.r.Intn(g.objects-hot)
+	default:
+		return g.r.Intn(g.objects)
+	}
+}
+
+// otherPath is a path no other request uses, like
+// /object-3.missing
+func (g *generator) otherPath(kind string) string {
+	g.others++
+	return fmt.Sprintf(g.m.Paths, g.others) + "." + kind
+}
@@ -0,0 +1,233 @@
+package loadtesting
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// model is a small workload, ten minutes ramping up to 100 tps
+const model = `{
+	"seed": 7,
+	"start": "2024-05-01 09:00:00",
+	"paths": "/img/%d.jpg",
+	"objects": 1000,
+	"popularity": {"kind": "zipf", "s": 1.1},
+	"ops": {"GET": 0.9, "PUT": 0.08, "DELETE": 0.02},
+	"sizes": {
+		"GET": {"kind": "lognormal", "mu": 9, "sigma": 1},
+		"PUT": {"kind": "uniform", "min": 1000, "max": 2000}
+	},
+	"codes": {"GET": {"200": 0.95, "404": 0.05}},
+	"rates": [
+		{"seconds": 60, "rate": 10, "to": 100},
+		{"seconds": 540, "rate": 100}
+	]
+}`
+
+// mustSynthesize makes the records of a model
+func mustSynthesize(t *testing.T, text string, scale, longer float64) [][]string {
+	t.Helper()
+	m, err := readModel(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.scale(scale, longer)
+	var out bytes.Buffer
+	if err = synthesize(m, &out); err != nil {
+		t.Fatal(err)
+	}
+	var records [][]string
+	r := newPerfReader(&out)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+// TestSynthesize checks that a model makes the workload it describes,
+// in a form runLoadTest and mkLoadTestFiles accept
+func TestSynthesize(t *testing.T) {
+	savedConf := conf
+	defer func() { conf = savedConf }()
+	conf = Config{R: true, W: true}
+	records := mustSynthesize(t, model, 0, 0)
+
+	// 55 seconds of ramp and 540 at 100
+	if n := len(records); n < 55000*0.95 || n > 59500*1.05 {
+		t.Errorf("got %d records, want about 59500", n)
+	}
+	ops := make(map[string]int)
+	sizes := make(map[string]string)
+	var missing int
+	last, _ := recordTime("2024-05-01", "09:00:00")
+	for _, r := range records {
+		if err := checkRecord(r); err != nil {
+			t.Fatalf("record %q is malformed, %v", r, err)
+		}
+		at, err := recordTime(r[dateField], r[timeField])
+		if err != nil || at.Before(last) {
+			t.Fatalf("record %q is out of order, or undated", r)
+		}
+		last = at
+		ops[r[operatorField]]++
+
+		path := r[pathField]
+		switch {
+		case r[returnCodeField] == "404":
+			missing++
+			if !strings.HasSuffix(path, ".missing") {
+				t.Errorf("404 of %s isn't of a path of its own", path)
+			}
+		case r[operatorField] == "DELETE":
+			if !strings.HasSuffix(path, ".deleted") || r[returnCodeField] != "204" {
+				t.Errorf("DELETE %s %s isn't a 204 of a path of its own", path, r[returnCodeField])
+			}
+		default:
+			if size, ok := sizes[path]; ok && size != r[bytesField] {
+				t.Fatalf("%s was %s bytes, then %s", path, size, r[bytesField])
+			}
+			sizes[path] = r[bytesField]
+		}
+	}
+	for op, want := range map[string]float64{"GET": 0.9, "PUT": 0.08, "DELETE": 0.02} {
+		if got := float64(ops[op]) / float64(len(records)); math.Abs(got-want) > 0.01 {
+			t.Errorf("%s is %.3f of the records, want %.3f", op, got, want)
+		}
+	}
+	if got := float64(missing) / float64(ops["GET"]); math.Abs(got-0.05) > 0.01 {
+		t.Errorf("%.3f of GETs are 404s, want 0.05", got)
+	}
+	if len(sizes) > 1000 {
+		t.Errorf("%d objects were used, of 1000", len(sizes))
+	}
+	if end, _ := recordTime("2024-05-01", "09:10:00"); end.Sub(last).Seconds() > 1 || last.After(end) {
+		t.Errorf("the last request is at %s, want just before %s", last, end)
+	}
+
+	// and mkLoadTestFiles creates what the GETs want, as big as they want
+	files := selectFiles(math.MaxInt, &sliceReader{records: records}, "model", false)
+	for _, w := range files {
+		if strings.HasSuffix(w.path, ".deleted") {
+			continue
+		}
+		if want, _ := strconv.ParseInt(sizes[w.path], 10, 64); w.size != want {
+			t.Errorf("mkLoadTestFiles would make %s %d bytes, want %d", w.path, w.size, want)
+		}
+	}
+}
+
+// sliceReader is a recordReader of records already read
+type sliceReader struct {
+	records [][]string
+}
+
+// Read returns the next record
+func (s *sliceReader) Read() ([]string, error) {
+	if len(s.records) == 0 {
+		return nil, io.EOF
+	}
+	r := s.records[0]
+	s.records = s.records[1:]
+	return r, nil
+}
+
+// TestSynthesizeRepeatable checks that a seed makes the same workload,
+// another seed a different one, and that scaling keeps the mix
+func TestSynthesizeRepeatable(t *testing.T) {
+	a := mustSynthesize(t, model, 0, 0)
+	b := mustSynthesize(t, model, 0, 0)
+	if len(a) != len(b) {
+		t.Fatalf("the same seed made %d and %d records", len(a), len(b))
+	}
+	for i := range a {
+		if strings.Join(a[i], " ") != strings.Join(b[i], " ") {
+			t.Fatalf("the same seed made %q and %q", a[i], b[i])
+		}
+	}
+	c := mustSynthesize(t, strings.Replace(model, `"seed": 7`, `"seed": 8`, 1), 0, 0)
+	if strings.Join(a[0], " ") == strings.Join(c[0], " ") && strings.Join(a[1], " ") == strings.Join(c[1], " ") {
+		t.Errorf("seeds 7 and 8 started the same, %q", a[:2])
+	}
+
+	bigger := mustSynthesize(t, model, 2, 0.5)
+	if n := float64(len(bigger)) / float64(len(a)); n < 0.95 || n > 1.05 {
+		t.Errorf("twice the rate for half the time made %.2f times the records", n)
+	}
+}
+
+// TestSynthesizePopularity checks the share the most popular objects get
+func TestSynthesizePopularity(t *testing.T) {
+	base := `{"objects": 1000, "ops": {"GET": 1}, "sizes": {"GET": {"kind": "fixed", "value": 10}},
+		"rates": [{"seconds": 100, "rate": 200}], `
+	tests := []struct {
+		popularity string
+		top        int     // objects
+		share      float64 // of the requests they get
+	}{
+		{`"popularity": {"kind": "uniform"}}`, 100, 0.1},
+		{`"popularity": {"kind": "hotset", "hot": 0.1, "share": 0.9}}`, 100, 0.9},
+		// H(100,1)/H(1000,1), about 5.19/7.49
+		{`"popularity": {"kind": "zipf", "s": 1}}`, 100, 0.69},
+		{`"newObjects": 1, "popularity": {"kind": "uniform"}}`, 1, 0.00005},
+	}
+	for _, test := range tests {
+		records := mustSynthesize(t, base+test.popularity, 0, 0)
+		counts := make(map[string]int)
+		for _, r := range records {
+			counts[r[pathField]]++
+		}
+		var top []int
+		for _, n := range counts {
+			top = append(top, n)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(top)))
+		var n int
+		for _, c := range top[:test.top] {
+			n += c
+		}
+		if got := float64(n) / float64(len(records)); math.Abs(got-test.share) > 0.05 {
+			t.Errorf("%s: the top %d objects got %.3f of requests, want %.3f",
+				test.popularity, test.top, got, test.share)
+		}
+	}
+}
+
+// TestReadModel checks that impossible models are rejected
+func TestReadModel(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{`{"objects": 1, "ops": {"GET": 1}, "sizes": {"GET": {"kind": "fixed"}}, "rates": [{"seconds": 1, "rate": 1}]}`, ""},
+		{`{"objects": 1, "ops": {"POST": 1}, "rates": [{"seconds": 1, "rate": 1}]}`, "not GET, PUT or DELETE"},
+		{`{"objects": 1, "ops": {"GET": 1}, "rates": [{"seconds": 1, "rate": 1}]}`, "has no sizes"},
+		{`{"objects": 1, "ops": {"DELETE": 1}, "rates": []}`, "no rates"},
+		{`{"objects": 0, "ops": {"DELETE": 1}, "rates": [{"seconds": 1, "rate": 1}]}`, "no objects"},
+		{`{"objects": 1, "ops": {"DELETE": 0}, "rates": [{"seconds": 1, "rate": 1}]}`, "none with any weight"},
+		{`{"objects": 1, "ops": {"DELETE": 1}, "popularity": {"kind": "pareto"}, "rates": [{"seconds": 1, "rate": 1}]}`, "unknown popularity"},
+		{`{"objects": 1, "ops": {"DELETE": 1}, "paths": "/%s", "rates": [{"seconds": 1, "rate": 1}]}`, "exactly one %d"},
+		{`{"objects": 1, "ops": {"GET": 1}, "sizes": {"GET": {"kind": "empirical", "quantiles": [3, 1]}}, "rates": [{"seconds": 1, "rate": 1}]}`, "in order"},
+		{`{"objects": 1, "ops": {"DELETE": 1}, "codes": {"DELETE": {"ok": 1}}, "rates": [{"seconds": 1, "rate": 1}]}`, "not a number"},
+		{`{"objects": 1, "op": {"DELETE": 1}}`, "unknown field"},
+	}
+	for _, test := range tests {
+		_, err := readModel(strings.NewReader(test.model))
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: got %v, want no error", test.model, err)
+		case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+			t.Errorf("%s: got %v, want %q", test.model, err, test.want)
+		}
+	}
+}
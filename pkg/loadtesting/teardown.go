@@ -0,0 +1,74 @@
+package loadtesting
+
+// teardown deletes the test data mkLoadTestFiles and write tests leave
+// behind, so object stores and disks don't fill up with it. It takes
+// the same perf file, and deletes what mkLoadTestFiles would create and
+// what the PUTs in it did, or a list of paths, one per line, such as
+// mkLoadTestFiles' manifest.
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// Teardown deletes the files or objects a perf file or list names
+func Teardown(f *os.File, filename, baseURL string, cfg Config) {
+	conf = cfg
+	if conf.Debug {
+		log.Printf("in Teardown(f *os.File, filename=%s, baseURL=%s)", filename, baseURL)
+	}
+
+	var files []wantedFile
+	if conf.TeardownList {
+		files = readPathList(f, filename)
+	} else {
+		files = selectFiles(math.MaxInt, newPerfReader(f), filename, true)
+	}
+	if conf.DryRun {
+		// a list of what we'd delete, which can be edited and fed back
+		for _, w := range files {
+			fmt.Println(w.path)
+		}
+		log.Printf("dry run, would have deleted %d files\n", len(files))
+		return
+	}
+
+	mustSetDataOperation(baseURL)
+	d, ok := op.(deleteOperation)
+	if !ok {
+		log.Fatalf("protocol %d can't delete, halting\n", conf.Protocol)
+	}
+	inParallel(files, conf.TeardownWorkers, conf.TeardownRate, func(w wantedFile) {
+		d.Delete(w.path, "", options{})
+	})
+}
+
+// readPathList reads paths, the first field of each line, ignoring
+// comments, so a manifest is also a list
+func readPathList(r io.Reader, filename string) []wantedFile {
+	var files []wantedFile
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		path := strings.Fields(line)[0]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		files = append(files, wantedFile{path: path})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Fatal error reading %s: %s, halting\n", filename, err)
+	}
+	return files
+}
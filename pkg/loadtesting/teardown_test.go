@@ -0,0 +1,106 @@
+package loadtesting
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// TestTeardownFS populates a directory, as a write test would too,
+// then dry-runs and tears it down
+func TestTeardownFS(t *testing.T) {
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+	root := t.TempDir()
+	captureStdout(t, func() {
+		MkLoadTestFiles(mkPopulate(t), "populate.csv", root, 0, 100, Config{Protocol: FilesystemProtocol})
+	})
+	// the write test's PUT
+	if err := os.WriteFile(filepath.Join(root, "upload.jpg"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doomed := []string{"/a.jpg", "/upload.jpg", "/old.jpg", "/dir/b.jpg"} // in the file's order
+
+	out := captureStdout(t, func() {
+		Teardown(mkPopulate(t), "populate.csv", root, Config{Protocol: FilesystemProtocol, DryRun: true})
+	})
+	if got := strings.Fields(out); strings.Join(got, " ") != strings.Join(doomed, " ") {
+		t.Errorf("dry run listed %v, want %v", got, doomed)
+	}
+	for _, path := range doomed {
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			t.Errorf("dry run deleted %s", path)
+		}
+	}
+
+	cfg := Config{Protocol: FilesystemProtocol, TeardownWorkers: 2, TeardownRate: 1000}
+	out = captureStdout(t, func() { Teardown(mkPopulate(t), "populate.csv", root, cfg) })
+	if n := strings.Count(out, " 204 DELETE"); n != len(doomed) {
+		t.Errorf("got %d deletes, want %d, in %q", n, len(doomed), out)
+	}
+	for _, path := range doomed {
+		if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+			t.Errorf("%s wasn't deleted", path)
+		}
+	}
+}
+
+// TestTeardownREST deletes what was put on a REST server, from a list
+func TestTeardownREST(t *testing.T) {
+	s, err := newSimulator(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	savedConf, savedOp, savedClient, savedAuth := conf, op, httpClient, auth
+	defer func() { conf, op, httpClient, auth = savedConf, savedOp, savedClient, savedAuth }()
+	manifest := filepath.Join(t.TempDir(), "manifest")
+	captureStdout(t, func() {
+		MkLoadTestFiles(mkPopulate(t), "populate.csv", server.URL, 0, 100,
+			Config{Protocol: RESTProtocol, MkManifest: manifest})
+	})
+
+	list := mustOpen(t, manifest)
+	out := captureStdout(t, func() {
+		Teardown(list, manifest, server.URL, Config{Protocol: RESTProtocol, TeardownList: true})
+	})
+	if n := strings.Count(out, " 204 DELETE"); n != len(created) {
+		t.Errorf("got %d deletes, want %d, in %q", n, len(created), out)
+	}
+	if len(s.stored) != 0 {
+		t.Errorf("%d objects left after teardown", len(s.stored))
+	}
+	// something already gone is a 404, and we carry on
+	out = captureStdout(t, func() {
+		Teardown(mustOpen(t, manifest), manifest, server.URL, Config{Protocol: RESTProtocol, TeardownList: true})
+	})
+	if n := strings.Count(out, " 404 DELETE"); n != len(created) {
+		t.Errorf("a second teardown got %q, want all 404s", out)
+	}
+}
+
+// TestTeardownS3 empties a bucket of what mkLoadTestFiles put there
+func TestTeardownS3(t *testing.T) {
+	withS3(t, Config{}, func(url string) {
+		savedClient, savedAuth := httpClient, auth
+		defer func() { httpClient, auth = savedClient, savedAuth }()
+		cfg := Config{Protocol: S3Protocol, S3Key: "key", S3Secret: "secret", S3Bucket: "test"}
+		captureStdout(t, func() { MkLoadTestFiles(mkPopulate(t), "populate.csv", url, 0, 100, cfg) })
+
+		out := captureStdout(t, func() { Teardown(mkPopulate(t), "populate.csv", url, cfg) })
+		// and the PUT's, which isn't there
+		if n := strings.Count(out, " 204 DELETE"); n != len(created)+1 {
+			t.Errorf("got %d deletes, want %d, in %q", n, len(created)+1, out)
+		}
+		list, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String("test")})
+		if err != nil || len(list.Contents) != 0 {
+			t.Errorf("after teardown, the bucket has %v, %v", list, err)
+		}
+	})
+}
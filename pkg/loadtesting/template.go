@@ -0,0 +1,236 @@
+package loadtesting
+
+// Template the paths, bodies and headers of records before they are sent,
+// so that, for example, a write test can use unique object names or a
+// replay can rotate through a list of user ids. References look like
+// ${name}, and are
+//	${run}             an id for this run
+//	${worker}          the number of the worker sending the request
+//	${counter}         a count of requests, across all workers
+//	${worker.counter}  a count of this worker's requests
+//	${uuid}            a random uuid
+//	${random}          a random number, or ${random.N} for one from 0 to N-1
+//	${time}            the time in seconds since the epoch, or
+//	${time.ms}         in milliseconds, or ${time.iso} as RFC 3339
+//	${data.column}     a column of the data file, taking the next row for each request
+//	${name}            a variable, from --vars or captured from a response,
+//	                   see extract.go
+// Anything else is left alone.
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// templateRE matches a ${name} reference
+var templateRE = regexp.MustCompile(`\$\{([A-Za-z0-9_.\-]+)\}`)
+
+// workerState is what each worker goroutine knows about itself
+type workerState struct {
+	id       int
+	requests int64
+	client   *http.Client // nil to share httpClient
+}
+
+var workerCount int64 // used to number the workers
+
+// newWorkerState numbers a new worker
+func newWorkerState() *workerState {
+	return &workerState{id: int(atomic.AddInt64(&workerCount, 1))}
+}
+
+// templater holds the per-run state of templating
+type templater struct {
+	runID    string
+	requests int64 // across all workers
+
+	mu   sync.RWMutex
+	vars map[string]string // --vars, and values captured from responses
+
+	columns map[string]int
+	rows    [][]string
+	nextRow int64
+}
+
+var templates *templater // the run's variables, set up by runLoadTest
+
+// expanding is true if records are expanded, with --template, --vars or
+// --data, or once a record extracts something to use as ${name}
+var expanding atomic.Bool
+
+// newTemplater sets up templating from the --vars string and an optional data file
+func newTemplater(vars, dataFile string) (*templater, error) {
+	t := &templater{
+		runID: randomHex(8),
+		vars:  make(map[string]string),
+	}
+	for _, v := range strings.Fields(vars) {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("vars must contain name=value pairs, found %q instead", v)
+		}
+		t.vars[name] = value
+	}
+	if dataFile != "" {
+		if err := t.loadData(dataFile); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// loadData reads a csv data file whose first line names the columns
+func (t *templater) loadData(dataFile string) error {
+	f, err := os.Open(dataFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return fmt.Errorf("could not read data file %s, %v", dataFile, err)
+	}
+	if len(rows) < 2 {
+		return fmt.Errorf("data file %s needs a line of column names and at least one line of data", dataFile)
+	}
+	t.columns = make(map[string]int)
+	for i, name := range rows[0] {
+		t.columns[strings.TrimSpace(name)] = i
+	}
+	t.rows = rows[1:]
+	return nil
+}
+
+// setVar sets a variable, such as one captured from a response
+func (t *templater) setVar(name, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.vars[name] = value
+}
+
+// getVar gets a variable
+func (t *templater) getVar(name string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	v, ok := t.vars[name]
+	return v, ok
+}
+
+// expandRecord returns a copy of a record with the path, body and
+// options expanded, using the variables of session s, if any, before
+// those of the run. All the references to the data file in one record
+// use the same row.
+func (t *templater) expandRecord(r []string, w *workerState, s *userSession) []string {
+	var row []string
+
+	t.countRequest(w)
+	expanded := append([]string(nil), r...)
+	lookup := func(name string) (string, bool) {
+		if strings.HasPrefix(name, "data.") {
+			if row == nil && len(t.rows) > 0 {
+				row = t.rows[(atomic.AddInt64(&t.nextRow, 1)-1)%int64(len(t.rows))]
+			}
+			i, ok := t.columns[strings.TrimPrefix(name, "data.")]
+			if !ok || i >= len(row) {
+				return "", false
+			}
+			return row[i], true
+		}
+		return t.value(name, w, s)
+	}
+	expand := func(s string) string {
+		if !strings.Contains(s, "${") {
+			return s
+		}
+		return templateRE.ReplaceAllStringFunc(s, func(ref string) string {
+			v, ok := lookup(ref[2 : len(ref)-1])
+			if !ok {
+				if conf.Debug {
+					log.Printf("template reference %s is undefined, left as is\n", ref)
+				}
+				return ref
+			}
+			return v
+		})
+	}
+
+	expanded[pathField] = expand(expanded[pathField])
+	for i := bodyField; i < len(expanded); i++ {
+		expanded[i] = expand(expanded[i])
+	}
+	return expanded
+}
+
+// countRequest counts a request for ${counter} and ${worker.counter}
+func (t *templater) countRequest(w *workerState) {
+	atomic.AddInt64(&t.requests, 1)
+	atomic.AddInt64(&w.requests, 1) // sessions may count from another goroutine
+}
+
+// value returns the value of everything except data file columns
+func (t *templater) value(name string, w *workerState, s *userSession) (string, bool) {
+	kind, arg, _ := strings.Cut(name, ".")
+	switch {
+	case name == "run":
+		return t.runID, true
+	case name == "worker":
+		return strconv.Itoa(w.id), true
+	case name == "counter":
+		return strconv.FormatInt(atomic.LoadInt64(&t.requests), 10), true
+	case name == "worker.counter":
+		return strconv.FormatInt(atomic.LoadInt64(&w.requests), 10), true
+	case name == "uuid":
+		return randomUUID(), true
+	case kind == "random":
+		limit := int64(1 << 62)
+		if arg != "" {
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil || n <= 0 {
+				return "", false
+			}
+			limit = n
+		}
+		n, _ := rand.Int(rand.Reader, big.NewInt(limit))
+		return n.String(), true
+	case name == "time":
+		return strconv.FormatInt(time.Now().Unix(), 10), true
+	case name == "time.ms":
+		return strconv.FormatInt(time.Now().UnixMilli(), 10), true
+	case name == "time.iso":
+		return time.Now().Format(time.RFC3339), true
+	}
+	if s != nil {
+		if v, ok := s.getVar(name); ok {
+			return v, true
+		}
+	}
+	return t.getVar(name)
+}
+
+// randomHex returns n random bytes, in hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// randomUUID returns a version 4 uuid
+func randomUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
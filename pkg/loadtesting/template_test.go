@@ -0,0 +1,54 @@
+package loadtesting
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestExpandRecord checks variables, data rows and worker references
+func TestExpandRecord(t *testing.T) {
+	dataFile := filepath.Join(t.TempDir(), "users.csv")
+	err := os.WriteFile(dataFile, []byte("user,token\nalice,a1\nbob,b2\n"), 0644)
+	if err != nil {
+		t.Fatalf("could not write %s, %v", dataFile, err)
+	}
+	tmpl, err := newTemplater("tenant=t1", dataFile)
+	if err != nil {
+		t.Fatalf("newTemplater failed, %v", err)
+	}
+	w := &workerState{id: 3}
+	r := []string{"2024-05-01", "10:00:00", "0", "0", "0", "10",
+		"/${tenant}/${data.user}/${worker}-${worker.counter}", "200", "POST",
+		`{"user": "${data.user}"}`, "header=Authorization: Bearer ${data.token}", "${unknown}"}
+
+	tests := []struct {
+		path, body, header string
+	}{
+		{"/t1/alice/3-1", `{"user": "alice"}`, "header=Authorization: Bearer a1"},
+		{"/t1/bob/3-2", `{"user": "bob"}`, "header=Authorization: Bearer b2"},
+		{"/t1/alice/3-3", `{"user": "alice"}`, "header=Authorization: Bearer a1"},
+	}
+	for _, test := range tests {
+		got := tmpl.expandRecord(r, w, nil)
+		if got[pathField] != test.path {
+			t.Errorf("path = %q, want %q", got[pathField], test.path)
+		}
+		if got[bodyField] != test.body {
+			t.Errorf("body = %q, want %q", got[bodyField], test.body)
+		}
+		if got[optionsField] != test.header {
+			t.Errorf("header = %q, want %q", got[optionsField], test.header)
+		}
+		if got[optionsField+1] != "${unknown}" {
+			t.Errorf("undefined reference became %q, want it left alone", got[optionsField+1])
+		}
+	}
+	if !strings.Contains(r[pathField], "${") {
+		t.Errorf("expandRecord changed its input, %q", r)
+	}
+	if _, err = newTemplater("novalue", ""); err == nil {
+		t.Errorf("newTemplater accepted a var without a value")
+	}
+}
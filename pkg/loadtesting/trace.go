@@ -0,0 +1,123 @@
+package loadtesting
+
+// Time the parts of a request that net/http doesn't report, using
+// httptrace, so that when latency grows we can tell whether it was
+// DNS, connecting, the TLS handshake or the server's think time.
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// traceColumns are the timings we add to result lines, in order
+var traceColumns = []string{"dns", "connect", "tls", "ttfb"}
+
+// requestTiming is what httptrace saw of one request. The hooks can run
+// on other goroutines, so it's locked.
+type requestTiming struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dns          time.Duration
+	connectStart time.Time
+	connect      time.Duration
+	tlsStart     time.Time
+	tlsHandshake time.Duration // zero if there was no handshake
+	wrote        time.Time
+	ttfb         time.Duration // from writing the request to the first byte of the response
+	reused       bool
+	proto        string // as negotiated, eg HTTP/2.0
+}
+
+// withTiming returns a copy of req that records its timing
+func withTiming(req *http.Request) (*http.Request, *requestTiming) {
+	t := &requestTiming{}
+	locked := func(f func()) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		f()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			locked(func() { t.dnsStart = time.Now() })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			locked(func() { t.dns = time.Since(t.dnsStart) })
+		},
+		ConnectStart: func(_, _ string) {
+			locked(func() {
+				if t.connectStart.IsZero() { // the first of several, if dialing in parallel
+					t.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			locked(func() {
+				if err == nil && t.connect == 0 {
+					t.connect = time.Since(t.connectStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			locked(func() { t.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			locked(func() {
+				if err == nil && !t.tlsStart.IsZero() {
+					t.tlsHandshake = time.Since(t.tlsStart)
+				}
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			locked(func() { t.reused = info.Reused })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			locked(func() { t.wrote = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			locked(func() {
+				if !t.wrote.IsZero() {
+					t.ttfb = time.Since(t.wrote)
+				}
+			})
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), t
+}
+
+// setProto records the protocol of the response
+func (t *requestTiming) setProto(proto string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.proto = proto
+}
+
+// annotation returns the timing as key=value fields to append to a
+// result line. With --trace that's all of them, in seconds, as in
+// " dns=0.001 connect=0.002 tls=0.010 ttfb=0.120 reused=false", otherwise
+// the tls handshake time, if there was one. The protocol follows, as
+// " proto=HTTP/2.0", if there's a choice of them. Lines differ in which
+// fields they have, so readers should find them by key, not by position.
+func (t *requestTiming) annotation() string {
+	var s string
+
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case conf.Trace:
+		s = fmt.Sprintf(" dns=%f connect=%f tls=%f ttfb=%f reused=%t",
+			t.dns.Seconds(), t.connect.Seconds(), t.tlsHandshake.Seconds(), t.ttfb.Seconds(), t.reused)
+	case t.tlsHandshake > 0:
+		s = fmt.Sprintf(" tls=%f", t.tlsHandshake.Seconds())
+	}
+	if t.proto != "" && (conf.Trace || (conf.HTTPVersion != "" && conf.HTTPVersion != "1.1")) {
+		s += " proto=" + t.proto
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package loadtesting
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTraceColumns checks the breakdown of a new and a reused connection,
+// and that describe aggregates it
+func TestTraceColumns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond) // think time
+	}))
+	defer server.Close()
+	savedConf := conf
+	defer func() { conf = savedConf }()
+	conf = Config{Trace: true}
+	client, err := newHTTPClient(conf)
+	if err != nil {
+		t.Fatalf("newHTTPClient failed, %v", err)
+	}
+
+	w := &workload{traced: make(map[string][]float64)}
+	for i, wantReused := range []bool{false, true} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req, timing := withTiming(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request %d failed, %v", i, err)
+		}
+		resp.Body.Close() // nolint
+
+		var dns, connect, tls, ttfb float64
+		var reused bool
+		annotation := timing.annotation()
+		n, err := fmt.Sscanf(annotation, " dns=%f connect=%f tls=%f ttfb=%f reused=%t",
+			&dns, &connect, &tls, &ttfb, &reused)
+		if err != nil || n != 5 {
+			t.Fatalf("request %d, annotation %q didn't parse, %v", i, annotation, err)
+		}
+		if reused != wantReused {
+			t.Errorf("request %d, reused = %v, want %v", i, reused, wantReused)
+		}
+		if (connect > 0) == wantReused {
+			t.Errorf("request %d, connect = %f with reused = %v", i, connect, wantReused)
+		}
+		if ttfb < 0.02 {
+			t.Errorf("request %d, ttfb = %f, want at least the server's 0.02", i, ttfb)
+		}
+		w.addTrace(append([]string{"2024-05-01", "10:00:00", "0.02", "0", "0", "0", "/", "200", "GET", "0"},
+			strings.Fields(annotation)...))
+	}
+	if len(w.traced["ttfb"]) != 2 || len(w.traced["connect"]) != 2 || w.reused != 1 {
+		t.Errorf("describe collected %v, with %d reused, want 2 of each and 1 reused", w.traced, w.reused)
+	}
+
+	conf.Trace = false
+	if got := (&requestTiming{ttfb: time.Second}).annotation(); got != "" {
+		t.Errorf("annotation without --trace or a handshake = %q, want nothing", got)
+	}
+	if got := (&requestTiming{tlsHandshake: time.Second}).annotation(); got != " tls=1.000000" {
+		t.Errorf("annotation of a handshake = %q, want its time", got)
+	}
+}
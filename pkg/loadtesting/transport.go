@@ -0,0 +1,371 @@
+package loadtesting
+
+// Build the http client from the configuration, so testing a server
+// with a private CA or client certificates, or with connections that
+// behave like those of real clients, doesn't need a new binary.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// newHTTPClient makes an http client as cfg asks. Zero values get the
+// defaults, the MaxIdleConnections and RequestTimeout constants and
+// net/http's own dial and idle timeouts. HTTPVersion chooses
+//
+//	"" or 1.1  HTTP/1.1 only
+//	auto       HTTP/2 if the server offers it over TLS, otherwise 1.1
+//	2          HTTP/2 over TLS only
+//	h2c        cleartext HTTP/2, assuming the server speaks it
+func newHTTPClient(cfg Config) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	maxIdle := cfg.MaxIdleConns
+	if maxIdle == 0 {
+		maxIdle = MaxIdleConnections
+	}
+	dialTimeout := cfg.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 30 * time.Second
+	}
+	idleTimeout := cfg.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = 90 * time.Second
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = time.Duration(RequestTimeout) * time.Second
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+
+	var transport http.RoundTripper
+	switch cfg.HTTPVersion {
+	case "", "1.1", "auto":
+		transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			DisableKeepAlives:   cfg.NoKeepAlive,
+			MaxConnsPerHost:     cfg.MaxConnsPerHost,
+			MaxIdleConnsPerHost: maxIdle,
+			IdleConnTimeout:     idleTimeout,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ForceAttemptHTTP2:   cfg.HTTPVersion == "auto",
+		}
+	case "2", "h2c":
+		// http2.Transport multiplexes on as few connections as it can
+		if cfg.NoKeepAlive || cfg.MaxConnsPerHost != 0 {
+			return nil, fmt.Errorf("-keep-alive=false and -max-conns-per-host don't apply to HTTP/2, use -h2-streams")
+		}
+		transport = newHTTP2Transport(dialer, tlsConfig, cfg.HTTPVersion == "h2c", cfg.H2Streams, idleTimeout)
+	default:
+		return nil, fmt.Errorf("unknown http version %q, expected 1.1, auto, 2 or h2c", cfg.HTTPVersion)
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// newHTTP2Transport makes a transport that only speaks HTTP/2, over TLS
+// or, with cleartext, as h2c with prior knowledge. It puts up to
+// streams concurrent requests on each connection before opening
+// another, or as many as the server allows if streams is zero.
+func newHTTP2Transport(dialer *net.Dialer, tlsConfig *tls.Config, cleartext bool,
+	streams int, idleTimeout time.Duration) *http2.Transport {
+	t := &http2.Transport{
+		TLSClientConfig: tlsConfig,
+		AllowHTTP:       cleartext,
+		IdleConnTimeout: idleTimeout,
+	}
+	t.ConnPool = &streamPool{
+		t:       t,
+		max:     streams,
+		conns:   make(map[string][]*http2.ClientConn),
+		dialing: make(map[string][]*pendingConn),
+		dial: func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil || cleartext {
+				return conn, err
+			}
+			return handshakeH2(ctx, conn, addr, tlsConfig)
+		},
+	}
+	return t
+}
+
+// handshakeH2 does a TLS handshake that must choose HTTP/2, reporting
+// it to httptrace, as net/http would
+func handshakeH2(ctx context.Context, conn net.Conn, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	cfg := &tls.Config{}
+	if tlsConfig != nil {
+		cfg = tlsConfig.Clone()
+	}
+	cfg.NextProtos = []string{"h2"}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tlsConn.ConnectionState(), err)
+	}
+	if err != nil {
+		conn.Close() // nolint
+		return nil, err
+	}
+	if p := tlsConn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		conn.Close() // nolint
+		return nil, fmt.Errorf("%s did not negotiate HTTP/2, got %q", addr, p)
+	}
+	return tlsConn, nil
+}
+
+// streamPool satisfies http2.ClientConnPool, limiting the streams
+// on each connection. Connections are dialed without holding mu, so
+// one slow handshake doesn't hold up requests with somewhere to go,
+// and requests wait for a dial already in flight rather than each
+// starting their own.
+type streamPool struct {
+	t    *http2.Transport
+	max  int // streams per connection, or 0 for the server's limit
+	dial func(ctx context.Context, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	conns   map[string][]*http2.ClientConn
+	dialing map[string][]*pendingConn
+}
+
+// pendingConn is a connection being dialed, and the requests waiting
+// for a stream on it
+type pendingConn struct {
+	done    chan struct{} // closed when cc or err is set
+	cc      *http2.ClientConn
+	err     error
+	waiters int // including the request that dialed
+}
+
+// GetClientConn returns a connection with a stream reserved for req
+func (p *streamPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	if proxy, err := http.ProxyFromEnvironment(req); err != nil || proxy != nil {
+		// http2.Transport can't tunnel through a proxy
+		return nil, fmt.Errorf("HTTP/2 can't go through the proxy for %s, use -http auto or unset it", req.URL.Host)
+	}
+	for {
+		p.mu.Lock()
+		if cc := p.reserveLocked(addr); cc != nil {
+			p.mu.Unlock()
+			return cc, nil
+		}
+		if d := p.pendingLocked(addr); d != nil {
+			// wait for it, and then try for a stream like everyone else
+			d.waiters++
+			p.mu.Unlock()
+			select {
+			case <-d.done:
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			if d.err != nil && !errors.Is(d.err, context.Canceled) && !errors.Is(d.err, context.DeadlineExceeded) {
+				return nil, d.err
+			}
+			// it has a stream for us, or its request gave up, so try again
+			continue
+		}
+		d := &pendingConn{done: make(chan struct{}), waiters: 1}
+		p.dialing[addr] = append(p.dialing[addr], d)
+		p.mu.Unlock()
+
+		d.cc, d.err = p.connect(req.Context(), addr)
+		p.mu.Lock()
+		pending := p.dialing[addr]
+		for i := range pending {
+			if pending[i] == d {
+				p.dialing[addr] = append(pending[:i], pending[i+1:]...)
+				break
+			}
+		}
+		if d.err == nil {
+			p.conns[addr] = append(p.conns[addr], d.cc)
+		}
+		p.mu.Unlock()
+		close(d.done)
+		return d.cc, d.err
+	}
+}
+
+// reserveLocked reserves a stream on a live connection to addr with
+// room for one, forgetting the closed ones. p.mu must be held.
+func (p *streamPool) reserveLocked(addr string) *http2.ClientConn {
+	live := p.conns[addr][:0]
+	var found *http2.ClientConn
+	for _, cc := range p.conns[addr] {
+		st := cc.State()
+		if st.Closed {
+			continue
+		}
+		live = append(live, cc)
+		if found == nil && (p.max == 0 || st.StreamsActive+st.StreamsReserved < p.max) &&
+			cc.ReserveNewRequest() {
+			found = cc
+		}
+	}
+	p.conns[addr] = live
+	return found
+}
+
+// pendingLocked returns a dial to addr with streams to spare for
+// another request, if there is one. p.mu must be held.
+func (p *streamPool) pendingLocked(addr string) *pendingConn {
+	for _, d := range p.dialing[addr] {
+		if p.max == 0 || d.waiters < p.max {
+			return d
+		}
+	}
+	return nil
+}
+
+// connect dials a new connection, with a stream reserved for the caller
+func (p *streamPool) connect(ctx context.Context, addr string) (*http2.ClientConn, error) {
+	conn, err := p.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := p.t.NewClientConn(conn)
+	if err != nil {
+		conn.Close() // nolint
+		return nil, err
+	}
+	if !cc.ReserveNewRequest() {
+		cc.Close() // nolint
+		return nil, fmt.Errorf("new HTTP/2 connection to %s refused a request", addr)
+	}
+	return cc, nil
+}
+
+// MarkDead forgets a connection
+func (p *streamPool) MarkDead(cc *http2.ClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conns := range p.conns {
+		for i, c := range conns {
+			if c == cc {
+				p.conns[addr] = append(conns[:i], conns[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// mustMakeHTTPClient makes a client, or halts
+func mustMakeHTTPClient(cfg Config) *http.Client {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		log.Fatalf("Fatal error setting up the http client: %s, halting\n", err)
+	}
+	return client
+}
+
+// newTLSConfig makes the tls settings, or nil for the defaults
+func newTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCA == "" && cfg.TLSCert == "" && cfg.TLSKey == "" && cfg.TLSServerName == "" &&
+		cfg.TLSMinVersion == "" && cfg.TLSCiphers == "" && !cfg.TLSInsecure {
+		return nil, nil
+	}
+	t := &tls.Config{
+		ServerName:         cfg.TLSServerName,
+		InsecureSkipVerify: cfg.TLSInsecure, // nolint, it's asked for explicitly
+	}
+	if cfg.TLSCA != "" {
+		pem, err := os.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA bundle %s, %v", cfg.TLSCA, err)
+		}
+		t.RootCAs = x509.NewCertPool()
+		if !t.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.TLSCA)
+		}
+	}
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		if cfg.TLSCert == "" || cfg.TLSKey == "" {
+			return nil, fmt.Errorf("a client certificate needs both a cert and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate, %v", err)
+		}
+		t.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.TLSMinVersion != "" {
+		v, err := tlsVersion(cfg.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		t.MinVersion = v
+	}
+	if cfg.TLSCiphers != "" {
+		ids, err := cipherSuites(cfg.TLSCiphers)
+		if err != nil {
+			return nil, err
+		}
+		t.CipherSuites = ids
+	}
+	return t, nil
+}
+
+// tlsVersion converts 1.0 to 1.3 into a tls version
+func tlsVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown tls version %q, expected 1.0, 1.1, 1.2 or 1.3", s)
+}
+
+// cipherSuites converts comma-separated names, like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, into ids. These only restrict
+// TLS 1.2 and earlier, as Go doesn't allow choosing 1.3's.
+func cipherSuites(names string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, c := range tls.CipherSuites() {
+		known[c.Name] = c.ID
+	}
+	for _, c := range tls.InsecureCipherSuites() {
+		known[c.Name] = c.ID
+	}
+	var ids []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
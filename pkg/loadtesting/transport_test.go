@@ -0,0 +1,206 @@
+package loadtesting
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTLSOptions checks the client against an httptest TLS server
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+	}))
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	ca := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"default", Config{}, false},
+		{"private CA", Config{TLSCA: ca}, true},
+		{"insecure", Config{TLSInsecure: true}, true},
+		{"server name", Config{TLSCA: ca, TLSServerName: "example.com"}, true},
+		{"wrong server name", Config{TLSCA: ca, TLSServerName: "wrong.test"}, false},
+		{"min version too high", Config{TLSCA: ca, TLSMinVersion: "1.3"}, false},
+		{"cipher", Config{TLSCA: ca, TLSCiphers: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, true},
+		{"wrong cipher", Config{TLSCA: ca, TLSCiphers: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}, false},
+	}
+	for _, test := range tests {
+		client, err := newHTTPClient(test.cfg)
+		if err != nil {
+			t.Errorf("%s: newHTTPClient failed, %v", test.name, err)
+			continue
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close() // nolint
+		}
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Get returned %v, want success = %v", test.name, err, test.ok)
+		}
+	}
+
+	for _, cfg := range []Config{{TLSCA: filepath.Join(dir, "missing.pem")},
+		{TLSCert: ca}, {TLSMinVersion: "2.0"}, {TLSCiphers: "TLS_NOT_A_CIPHER"}} {
+		if _, err := newHTTPClient(cfg); err == nil {
+			t.Errorf("newHTTPClient(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
+
+// TestClientCertificate checks mutual TLS, and that the handshake is
+// timed on a new connection but not on a reused one
+func TestClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "loadtester"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, _ := x509.ParseCertificate(der)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	cfg := Config{
+		TLSCA:   writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw),
+		TLSCert: writePEM(t, dir, "client.pem", "CERTIFICATE", der),
+		TLSKey:  writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER),
+	}
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		t.Fatalf("newHTTPClient failed, %v", err)
+	}
+	for i, wantTLS := range []bool{true, false} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req, timing := withTiming(req)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request %d failed, %v", i, err)
+		}
+		resp.Body.Close() // nolint
+		if got := resp.Header.Get("X-Client"); got != "loadtester" {
+			t.Errorf("request %d, server saw client %q, want loadtester", i, got)
+		}
+		if got := strings.HasPrefix(timing.annotation(), " tls="); got != wantTLS {
+			t.Errorf("request %d, annotation %q, want a handshake = %v", i, timing.annotation(), wantTLS)
+		}
+	}
+
+	cfg.TLSCert, cfg.TLSKey = "", ""
+	client, _ = newHTTPClient(cfg)
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close() // nolint
+		t.Errorf("Get without a client certificate succeeded")
+	}
+}
+
+// writePEM writes a pem file for a test
+func writePEM(t *testing.T, dir, name, kind string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestConnectionModes counts the connections a server sees for each mode
+func TestConnectionModes(t *testing.T) {
+	var mu sync.Mutex
+	var connections int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			connections++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		clients int // distinct clients, as with ClientPerWorker
+		want    int
+	}{
+		{"shared keep-alive", Config{}, 1, 1},
+		{"keep-alive off", Config{NoKeepAlive: true}, 1, 4},
+		{"client per worker", Config{ClientPerWorker: true}, 2, 2},
+	}
+	for _, test := range tests {
+		mu.Lock()
+		connections = 0
+		mu.Unlock()
+		for i := 0; i < test.clients; i++ {
+			client := mustMakeHTTPClient(test.cfg)
+			for j := 0; j < 4/test.clients; j++ {
+				resp, err := client.Get(server.URL)
+				if err != nil {
+					t.Fatalf("%s: Get failed, %v", test.name, err)
+				}
+				io.Copy(io.Discard, resp.Body) // nolint
+				resp.Body.Close()              // nolint
+			}
+		}
+		mu.Lock()
+		if connections != test.want {
+			t.Errorf("%s: server saw %d connections, want %d", test.name, connections, test.want)
+		}
+		mu.Unlock()
+	}
+
+	client := mustMakeHTTPClient(Config{Timeout: 50 * time.Millisecond})
+	if resp, err := client.Get(server.URL + "/slow"); err == nil {
+		resp.Body.Close() // nolint
+		t.Errorf("Get of a slow page succeeded despite a 50ms timeout")
+	}
+}
@@ -0,0 +1,302 @@
+package loadtesting
+
+// wsOps replays websocket conversations. A conversation is a series of
+// records with the operators
+//
+//	OPEN    connect to the url prefix plus the path
+//	SEND    send the body as a text message
+//	EXPECT  wait for a message, which must match the body, if any
+//	CLOSE   close the connection, reporting its message rates
+//
+// Records with the same session=name option share a connection, and
+// are run one at a time in order. Records without one are ignored, as
+// concurrent OPENs of the same path would replace, and close, each
+// other's connections. The number of concurrent connections is the
+// rate of OPENs times how long each lasts, so the usual progressive
+// load ramps it up.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// defaultExpectTimeout is how long EXPECT waits, without --timeout
+const defaultExpectTimeout = 30 * time.Second
+
+// These are the return codes we report for websocket operations
+const (
+	wsSwitched = http.StatusSwitchingProtocols // opened
+	wsMatched  = http.StatusOK                 // sent, or got what we expected
+	wsMismatch = http.StatusExpectationFailed  // got something else
+	wsTimeout  = http.StatusRequestTimeout     // got nothing in time
+	wsClosed   = 444                           // nginx's no response, the connection is gone
+)
+
+// WebSocketProto satisfies operation and messageOperation over websockets
+type WebSocketProto struct {
+	prefix string
+}
+
+// messageOperation is what a protocol that sends messages on long-lived
+// connections does, in addition to operation
+type messageOperation interface {
+	Open(path, oldRc string, o options)
+	Send(path, body, oldRc string, o options)
+	Expect(path, body, oldRc string, o options)
+	Close(path, oldRc string, o options)
+}
+
+// wsConn is an open connection and what's been done with it, which
+// only its session's records use, one at a time
+type wsConn struct {
+	ws       *websocket.Conn
+	opened   time.Time
+	lastSend time.Time
+	sent     int
+	received int
+	bytes    int
+}
+
+// wsConns are the open connections, by session
+var wsConns = struct {
+	sync.Mutex
+	open map[string]*wsConn
+}{open: make(map[string]*wsConn)}
+
+// Init checks the url is a websocket one
+func (p WebSocketProto) Init() {
+	if !strings.HasPrefix(p.prefix, "ws://") && !strings.HasPrefix(p.prefix, "wss://") {
+		log.Fatalf("websocket url %q must start with ws:// or wss://, halting\n", p.prefix)
+	}
+}
+
+// Get is not meaningful for websockets
+func (p WebSocketProto) Get(path, oldRc string, o options) {
+	log.Fatalf("GET is not meaningful for websockets, use OPEN, SEND, EXPECT and CLOSE, halting\n")
+}
+
+// Put is not meaningful for websockets
+func (p WebSocketProto) Put(path, size, oldRc string, o options) {
+	log.Fatalf("PUT is not meaningful for websockets, use OPEN, SEND, EXPECT and CLOSE, halting\n")
+}
+
+// Post is not meaningful for websockets
+func (p WebSocketProto) Post(path, size, oldRc, body string, o options) {
+	log.Fatalf("POST is not meaningful for websockets, use OPEN, SEND, EXPECT and CLOSE, halting\n")
+}
+
+// Open connects, reporting the handshake time as the latency
+func (p WebSocketProto) Open(path, oldRc string, o options) {
+	if conf.Debug {
+		log.Printf("in websocket.Open(%s)\n", path)
+	}
+	initial := time.Now()
+	config, err := p.config(path, o)
+	if err != nil {
+		p.report(initial, 0, 0, 0, path, http.StatusBadRequest, "OPEN", oldRc, "", err)
+		return
+	}
+	ws, err := websocket.DialConfig(config)
+	latency := time.Since(initial)
+	if err != nil {
+		p.report(initial, latency, 0, 0, path, http.StatusBadGateway, "OPEN", oldRc, "", err)
+		return
+	}
+
+	key := connKey(o)
+	wsConns.Lock()
+	old := wsConns.open[key]
+	wsConns.open[key] = &wsConn{ws: ws, opened: initial}
+	n := len(wsConns.open)
+	wsConns.Unlock()
+	if old != nil {
+		log.Printf("websocket %s was opened again without being closed\n", key)
+		old.ws.Close() // nolint
+	}
+	p.report(initial, latency, 0, 0, path, wsSwitched, "OPEN", oldRc, fmt.Sprintf(" open=%d", n), nil)
+}
+
+// config makes the dial settings, with the same headers and tls as http
+func (p WebSocketProto) config(path string, o options) (*websocket.Config, error) {
+	location := p.prefix + path
+	origin := "http" + strings.TrimPrefix(p.prefix, "ws")
+	config, err := websocket.NewConfig(location, origin)
+	if err != nil {
+		return nil, err
+	}
+	config.TlsConfig, err = newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	dialTimeout := conf.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 30 * time.Second
+	}
+	config.Dialer = &net.Dialer{Timeout: dialTimeout}
+
+	// build the headers as a request would have them
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	addHeaders(req, o)
+	config.Header = req.Header
+	return config, nil
+}
+
+// Send writes the body as one text message
+func (p WebSocketProto) Send(path, body, oldRc string, o options) {
+	if conf.Debug {
+		log.Printf("in websocket.Send(%s, %q)\n", path, body)
+	}
+	initial := time.Now()
+	c := lookupConn(o)
+	if c == nil {
+		p.report(initial, 0, 0, 0, path, wsClosed, "SEND", oldRc, "",
+			fmt.Errorf("no open connection for %s", connKey(o)))
+		return
+	}
+	initial = time.Now()
+	err := websocket.Message.Send(c.ws, body)
+	latency := time.Since(initial)
+	if err != nil {
+		p.report(initial, latency, 0, 0, path, wsClosed, "SEND", oldRc, "", err)
+		return
+	}
+	c.lastSend = initial
+	c.sent++
+	c.bytes += len(body)
+	p.report(initial, latency, latency, len(body), path, wsMatched, "SEND", oldRc, "", nil)
+}
+
+// Expect waits for a message, reporting the time since the last send
+// as the latency. An empty body matches anything, /re/ matches the
+// regular expression re, and anything else must match exactly.
+func (p WebSocketProto) Expect(path, body, oldRc string, o options) {
+	var msg string
+
+	if conf.Debug {
+		log.Printf("in websocket.Expect(%s, %q)\n", path, body)
+	}
+	initial := time.Now()
+	c := lookupConn(o)
+	if c == nil {
+		p.report(initial, 0, 0, 0, path, wsClosed, "EXPECT", oldRc, "",
+			fmt.Errorf("no open connection for %s", connKey(o)))
+		return
+	}
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = defaultExpectTimeout
+	}
+	c.ws.SetReadDeadline(time.Now().Add(timeout)) // nolint
+	err := websocket.Message.Receive(c.ws, &msg)
+	if !c.lastSend.IsZero() {
+		initial = c.lastSend
+	}
+	latency := time.Since(initial)
+	switch {
+	case err != nil && isTimeout(err):
+		p.report(initial, latency, 0, 0, path, wsTimeout, "EXPECT", oldRc, "", err)
+		return
+	case err != nil:
+		p.report(initial, latency, 0, 0, path, wsClosed, "EXPECT", oldRc, "", err)
+		return
+	}
+	c.received++
+	c.bytes += len(msg)
+	if len(o.extracts) > 0 {
+		o.extract(path, http.Header{}, []byte(msg))
+	}
+	rc := wsMatched
+	ok, err := matches(body, msg)
+	if !ok {
+		rc = wsMismatch
+		if err == nil {
+			err = fmt.Errorf("got %q, expected %q", msg, body)
+		}
+	}
+	p.report(initial, latency, 0, len(msg), path, rc, "EXPECT", oldRc, "", err)
+}
+
+// Close closes the connection, reporting how long it lasted and its
+// message rate
+func (p WebSocketProto) Close(path, oldRc string, o options) {
+	if conf.Debug {
+		log.Printf("in websocket.Close(%s)\n", path)
+	}
+	initial := time.Now()
+	key := connKey(o)
+	wsConns.Lock()
+	c := wsConns.open[key]
+	delete(wsConns.open, key)
+	wsConns.Unlock()
+	if c == nil {
+		p.report(initial, 0, 0, 0, path, wsClosed, "CLOSE", oldRc, "",
+			fmt.Errorf("no open connection for %s", key))
+		return
+	}
+	err := c.ws.Close()
+	latency := time.Since(initial)
+	lifetime := time.Since(c.opened)
+	rc := wsMatched
+	if err != nil {
+		rc = wsClosed
+	}
+	p.report(initial, latency, 0, c.bytes, path, rc, "CLOSE", oldRc,
+		fmt.Sprintf(" lifetime=%f sent=%d received=%d rate=%f", lifetime.Seconds(),
+			c.sent, c.received, float64(c.sent+c.received)/lifetime.Seconds()), err)
+}
+
+// report prints the result of an operation, and any error
+func (p WebSocketProto) report(initial time.Time, latency, transferTime time.Duration, size int,
+	path string, rc int, operator, oldRc, annotation string, err error) {
+	if err != nil {
+		log.Printf("websocket %s %s failed, %v\n", operator, path, err)
+		if conf.Crash {
+			log.Fatalf("halting.\n")
+		}
+	}
+	reportPerformance(initial, latency, transferTime, int64(size), path, rc, operator, oldRc, nil, annotation)
+}
+
+// connKey is the session, which every message record has
+func connKey(o options) string {
+	return "session=" + o.session
+}
+
+// lookupConn finds the open connection for a record, or nil
+func lookupConn(o options) *wsConn {
+	wsConns.Lock()
+	defer wsConns.Unlock()
+	return wsConns.open[connKey(o)]
+}
+
+// matches is true if msg is what the expectation asks for
+func matches(expected, msg string) (bool, error) {
+	switch {
+	case expected == "":
+		return true, nil
+	case len(expected) > 1 && strings.HasPrefix(expected, "/") && strings.HasSuffix(expected, "/"):
+		re, err := regexp.Compile(expected[1 : len(expected)-1])
+		if err != nil {
+			return false, fmt.Errorf("bad expected pattern %s, %v", expected, err)
+		}
+		return re.MatchString(msg), nil
+	}
+	return expected == msg, nil
+}
+
+// isTimeout is true if err is a read deadline passing
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
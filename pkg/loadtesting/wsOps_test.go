@@ -0,0 +1,80 @@
+package loadtesting
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// TestWebSocketConversation replays a conversation with an echo server
+func TestWebSocketConversation(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+			websocket.Message.Send(ws, "echo "+msg) // nolint
+		}
+	}))
+	defer server.Close()
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+	conf = Config{R: true, Cache: true, Timeout: 200 * time.Millisecond}
+	op = WebSocketProto{prefix: "ws" + strings.TrimPrefix(server.URL, "http")}
+	op.Init()
+
+	o := options{session: "u1"}
+	rate := strconv.Itoa(ExpectedRate)
+	tests := []struct {
+		record []string
+		want   string
+	}{
+		{[]string{"OPEN", ""}, " 101 OPEN " + rate + " open=1"},
+		{[]string{"SEND", "hello"}, " 5 /chat 200 SEND"},
+		{[]string{"EXPECT", "echo hello"}, " 10 /chat 200 EXPECT"},
+		{[]string{"SEND", "again"}, " 200 SEND"},
+		{[]string{"EXPECT", "/^echo a.*n$/"}, " 200 EXPECT"},
+		{[]string{"SEND", "hello"}, " 200 SEND"},
+		{[]string{"EXPECT", "goodbye"}, " 417 EXPECT"},
+		{[]string{"EXPECT", ""}, " 408 EXPECT"},
+		{[]string{"CLOSE", ""}, " /chat 200 CLOSE " + rate + " lifetime="},
+		{[]string{"SEND", "hello"}, " 444 SEND"},
+	}
+	for _, test := range tests {
+		r := []string{"2024-05-01", "10:00:00", "0", "0", "0", "0", "/chat", "0",
+			test.record[0], test.record[1]}
+		out := captureStdout(t, func() { perform(r, o) })
+		if !strings.Contains(out, test.want) {
+			t.Errorf("%s %q printed %q, want %q", test.record[0], test.record[1], out, test.want)
+		}
+		if test.record[0] == "CLOSE" && !strings.Contains(out, "sent=3 received=3") {
+			t.Errorf("CLOSE printed %q, want sent=3 received=3", out)
+		}
+	}
+}
+
+// TestWebSocketNeedsSession ignores records without a session, which
+// runLoadTest's workers would run at the same time
+func TestWebSocketNeedsSession(t *testing.T) {
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {}))
+	defer server.Close()
+	savedConf, savedOp := conf, op
+	defer func() { conf, op = savedConf, savedOp }()
+	conf = Config{R: true, Cache: true}
+	op = WebSocketProto{prefix: "ws" + strings.TrimPrefix(server.URL, "http")}
+	op.Init()
+
+	for _, operator := range []string{"OPEN", "SEND", "CLOSE"} {
+		r := []string{"2024-05-01", "10:00:00", "0", "0", "0", "0", "/feed", "0", operator, "tick"}
+		if out := captureStdout(t, func() { perform(r, options{}) }); out != "" {
+			t.Errorf("%s without a session printed %q, want nothing", operator, out)
+		}
+	}
+	wsConns.Lock()
+	defer wsConns.Unlock()
+	if n := len(wsConns.open); n != 0 {
+		t.Errorf("%d connections were opened without a session", n)
+	}
+}